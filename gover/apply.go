@@ -0,0 +1,184 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// doApply replaces the binaries, packages, source tree, and -include
+// paths of the Go tree in goroot() with those of saved build name.
+// The source tree may be saved as a directory, shared, or compressed.
+func doApply(name string) {
+	savePath := resolveBase(name)
+	base := filepath.Base(savePath)
+	root := goroot()
+	if !*force && isGitRepo(root) && gitCmd("status", "--porcelain") != "" {
+		log.Fatalf("Go tree %s has uncommitted changes that apply would overwrite; commit or stash them, or pass -force", root)
+	}
+	// Keep the build from being replaced while it's copied.
+	if unlock, err := lockBuild(base, false); err == nil {
+		defer unlock()
+	}
+	copyBuild(savePath, root, "applying")
+	infof("applied `%s' to %s\n", base, root)
+}
+
+// copyBuild replaces the binaries, packages, source tree, and -include
+// paths of the Go tree at root with those of the saved build at
+// savePath, making new copies of all of them. It shows progress as
+// doing.
+func copyBuild(savePath, root, doing string) {
+	base := filepath.Base(savePath)
+	meta := readMeta(savePath)
+
+	// Replace each directory the build saved under pkg, which
+	// depends on -pkg-tree when it was saved.
+	var trees []string
+	files, err := ioutil.ReadDir(filepath.Join(savePath, "pkg"))
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	for _, file := range files {
+		if file.Name() != "tool" {
+			trees = append(trees, filepath.Join("pkg", file.Name()))
+		}
+	}
+	files, err = ioutil.ReadDir(filepath.Join(savePath, "pkg", "tool"))
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	for _, file := range files {
+		trees = append(trees, filepath.Join("pkg", "tool", file.Name()))
+	}
+	for _, path := range meta.Include {
+		trees = append(trees, filepath.FromSlash(path))
+	}
+
+	startProgress(doing)
+	for _, binTool := range savedBin(savePath) {
+		curProgress.addTotal(filepath.Join(savePath, "bin", binTool))
+	}
+	for _, tree := range trees {
+		curProgress.addTotal(filepath.Join(savePath, tree))
+	}
+	for _, binTool := range savedBin(savePath) {
+		copyOut(filepath.Join(savePath, "bin", binTool), filepath.Join(root, "bin", binTool))
+	}
+	for _, tree := range trees {
+		if _, err := os.Lstat(filepath.Join(savePath, tree)); os.IsNotExist(err) {
+			continue
+		}
+		replaceTree(filepath.Join(savePath, tree), filepath.Join(root, tree))
+	}
+	switch {
+	case meta.NoSrc:
+		stopProgress()
+		infof("build `%s' was saved without its source tree (-no-src); leaving %s alone\n", base, filepath.Join(root, "src"))
+	case hasCompressedSrc(savePath):
+		// Unpack straight into the Go tree rather than through
+		// _extracted.
+		if err := os.RemoveAll(filepath.Join(root, "src")); err != nil {
+			log.Fatal(err)
+		}
+		f, err := os.Open(filepath.Join(savePath, compressedSrcName))
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = readTar(f, root, "")
+		f.Close()
+		if err != nil {
+			log.Fatalf("%s: %s", filepath.Join(savePath, compressedSrcName), err)
+		}
+	default:
+		src := filepath.Join(savePath, "src")
+		if shared := sharedSrc(src); shared != "" {
+			src = shared
+		}
+		replaceTree(src, filepath.Join(root, "src"))
+	}
+	stopProgress()
+}
+
+// replaceTree replaces the directory dst with a copy of src, with the
+// same directory modes and modification times.
+func replaceTree(src, dst string) {
+	if err := os.RemoveAll(dst); err != nil {
+		log.Fatal(err)
+	}
+	var dirs copiedDirs
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		target := dst + path[len(src):]
+		switch {
+		case info.IsDir():
+			return dirs.mkdir(target, info)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err == nil {
+				err = os.Symlink(link, target)
+			}
+			return err
+		}
+		copyOut(path, target)
+		return nil
+	})
+	if err == nil {
+		err = dirs.finish()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// copyOut copies the file src in a saved build to dst outside the
+// gover directory. Unlike cp, it makes a new copy, since files in
+// saved builds may be hard links into the deduplication cache.
+func copyOut(src, dst string) {
+	st, err := os.Stat(src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	done := logCommand("cp", src, dst)
+	in, err := os.Open(src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+		log.Fatal(err)
+	}
+	// Write a new file rather than into dst, which may be a hard
+	// link to something else.
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), ".gover-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = io.Copy(tmp, in)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), st.Mode())
+	}
+	if err == nil {
+		err = os.Chtimes(tmp.Name(), st.ModTime(), st.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Fatal(err)
+	}
+	done(st.Size())
+	curProgress.add(st.Size())
+}
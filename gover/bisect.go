@@ -0,0 +1,161 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+var (
+	bisectGood = flag.String("good", "", "for autobisect, saved build `name` the command succeeds with")
+	bisectBad  = flag.String("bad", "", "for autobisect, saved build `name` the command fails with")
+)
+
+// bisectSkip is the exit status with which a command tells autobisect
+// it can't test a build, as with "git bisect run".
+const bisectSkip = 125
+
+// doAutobisect finds the first saved build between -good and -bad,
+// in the order list sorts them, with which cmd fails, by running cmd
+// with builds in between like "gover with" and narrowing the range
+// by its exit status.
+func doAutobisect(cmd []string) {
+	if *bisectGood == "" || *bisectBad == "" {
+		log.Fatal("autobisect requires -good and -bad")
+	}
+	goodBase := filepath.Base(resolveBase(*bisectGood))
+	badBase := filepath.Base(resolveBase(*bisectBad))
+	if goodBase == badBase {
+		log.Fatalf("-good %s and -bad %s are the same build", *bisectGood, *bisectBad)
+	}
+	builds, err := listBuilds(listNames | listCommit | listMeta)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sortBuilds(builds)
+	goodIdx, badIdx := -1, -1
+	for i, b := range builds {
+		switch b.base {
+		case goodBase:
+			goodIdx = i
+		case badBase:
+			badIdx = i
+		}
+	}
+	// Search from the good build to the bad one, which may be
+	// older if the command is looking for a fix.
+	var span []*buildInfo
+	if goodIdx < badIdx {
+		span = builds[goodIdx : badIdx+1]
+	} else {
+		for i := goodIdx; i >= badIdx; i-- {
+			span = append(span, builds[i])
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Pass along all flags except -good and -bad.
+	var flags []string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "good" || f.Name == "bad" {
+			return
+		}
+		if l, ok := f.Value.(*stringList); ok {
+			for _, v := range *l {
+				flags = append(flags, fmt.Sprintf("-%s=%s", f.Name, v))
+			}
+			return
+		}
+		flags = append(flags, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+	})
+
+	skipped := make(map[int]bool)
+	lo, hi := 0, len(span)-1
+	for {
+		mid := bisectMidpoint(lo, hi, skipped)
+		if mid < 0 {
+			break
+		}
+		b := span[mid]
+		infof("testing %s (%d build(s) left)\n", b.shortName(), hi-lo-1-countSkipped(lo, hi, skipped))
+		args := append(append(append([]string{}, flags...), "with", b.base), cmd...)
+		c := exec.Command(exe, args...)
+		c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+		done := logCommand(exe, args...)
+		err := c.Run()
+		done(0)
+		status := 0
+		if ee, ok := err.(*exec.ExitError); ok {
+			status = ee.ExitCode()
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		switch {
+		case status == 0:
+			lo = mid
+		case status == bisectSkip:
+			skipped[mid] = true
+		case status > 0 && status < 128:
+			hi = mid
+		default:
+			// Like "git bisect run", stop if the command was
+			// killed or wants to abort.
+			log.Fatalf("command exited with status %d with build %s; stopping", status, b.shortName())
+		}
+	}
+
+	first := span[hi]
+	if countSkipped(lo, hi, skipped) == 0 {
+		fmt.Printf("first bad build: %s\n", describeBuild(first))
+		return
+	}
+	fmt.Printf("the first bad build could be any of:\n")
+	for i := lo + 1; i <= hi; i++ {
+		fmt.Println(describeBuild(span[i]))
+	}
+}
+
+// describeBuild returns the short name of b and its commit message, if
+// it has one.
+func describeBuild(b *buildInfo) string {
+	if b.commit.topLine == "" {
+		return b.shortName()
+	}
+	return b.shortName() + " " + b.commit.topLine
+}
+
+// bisectMidpoint returns the untested, unskipped index between lo
+// and hi closest to their midpoint, or -1 if there are none.
+func bisectMidpoint(lo, hi int, skipped map[int]bool) int {
+	mid := (lo + hi) / 2
+	for d := 0; mid-d > lo || mid+d < hi; d++ {
+		for _, i := range []int{mid - d, mid + d} {
+			if i > lo && i < hi && !skipped[i] {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// countSkipped returns the number of skipped indexes between lo and
+// hi.
+func countSkipped(lo, hi int, skipped map[int]bool) int {
+	n := 0
+	for i := range skipped {
+		if i > lo && i < hi {
+			n++
+		}
+	}
+	return n
+}
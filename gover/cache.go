@@ -5,11 +5,13 @@
 package main
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -20,8 +22,9 @@ var fullHashRe = regexp.MustCompile("^[0-9a-f]{40}$")
 var hashPlusRe = regexp.MustCompile(`^[0-9a-f]{40}(\+[0-9a-f]{10})?$`)
 
 // resolveName returns the path to the root of the named build and
-// whether or not that path exists. It will log an error and exit if
-// name is ambiguous. If the path does not exist, the returned path is
+// whether or not that path exists. If name is ambiguous, it asks the
+// user to choose a build on a terminal, and otherwise logs an error
+// and exits. If the path does not exist, the returned path is
 // where this build should be saved.
 func resolveName(name string) (path string, ok bool) {
 	// If the name exactly matches a saved version, return it.
@@ -31,6 +34,30 @@ func resolveName(name string) (path string, ok bool) {
 		return savePath, true
 	}
 
+	// If it's a name whose link doesn't resolve, such as an absolute
+	// link made before the saved build directory moved, look for
+	// its target in the saved build directory.
+	if base, err := readLinkBase(savePath); err == nil {
+		basePath := filepath.Join(*verDir, base)
+		if st, err := os.Stat(basePath); err == nil && st.IsDir() {
+			return basePath, true
+		}
+	}
+
+	// Try to resolve it as a pseudo-name.
+	if pick, ok := pseudoNames[name]; ok {
+		builds, err := listBuilds(listCommit)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(builds) == 0 {
+			return savePath, false
+		}
+		// Break ties between builds of the same commit by name.
+		sort.Stable(buildInfoSorter(builds))
+		return filepath.Join(*verDir, pick(builds).fullName()), true
+	}
+
 	// Otherwise, try to resolve it as an unambiguous hash prefix.
 	if hashNameRe.MatchString(name) {
 		nameParts := strings.SplitN(name, "+", 2)
@@ -39,8 +66,12 @@ func resolveName(name string) (path string, ok bool) {
 			log.Fatal(err)
 		}
 
-		var fullName string
+		var matches []string
 		for _, b := range builds {
+			if b.commitHash == "" {
+				// Builds saved with -hash have no commit hash.
+				continue
+			}
 			if !strings.HasPrefix(b.commitHash, nameParts[0]) {
 				continue
 			}
@@ -51,36 +82,60 @@ func resolveName(name string) (path string, ok bool) {
 				continue
 			}
 
-			// We found a match.
-			if fullName != "" {
-				log.Fatalf("ambiguous name `%s`", name)
-			}
-			fullName = b.fullName()
+			matches = append(matches, b.fullName())
 		}
-		if fullName != "" {
-			return filepath.Join(*verDir, fullName), true
+		switch len(matches) {
+		case 0:
+		case 1:
+			return filepath.Join(*verDir, matches[0]), true
+		default:
+			return filepath.Join(*verDir, pickAmbiguous(name, matches)), true
 		}
 	}
 
 	return savePath, false
 }
 
+// pseudoNames maps each pseudo-name to a function that picks the
+// build it refers to from a non-empty list of builds sorted from
+// oldest to newest. A saved build or name that's spelled the same
+// takes precedence.
+var pseudoNames = map[string]func([]*buildInfo) *buildInfo{
+	"latest": newest,
+	"oldest": oldest,
+	"first":  oldest,
+}
+
+func newest(builds []*buildInfo) *buildInfo { return builds[len(builds)-1] }
+func oldest(builds []*buildInfo) *buildInfo { return builds[0] }
+
+// unknownName exits with an error explaining that name does not refer
+// to a saved build.
+func unknownName(name string) {
+	if _, ok := pseudoNames[name]; ok {
+		exitf(exitNotFound, "no saved builds in %s for `%s'", *verDir, name)
+	}
+	exitf(exitNotFound, "unknown name `%s'", name)
+}
+
 type buildInfo struct {
-	commitHash string
+	base       string // name of the build's directory
+	commitHash string // empty if saved with -hash
 	deltaHash  string
 	names      []string
 	commit     *commit
+	saveTime   time.Time
 }
 
 func (i buildInfo) fullName() string {
-	if i.deltaHash == "" {
-		return i.commitHash
-	}
-	return i.commitHash + "+" + i.deltaHash
+	return i.base
 }
 
 func (i buildInfo) shortName() string {
 	// TODO: Print more than 7 characters if necessary.
+	if i.commitHash == "" {
+		return i.base
+	}
 	if i.deltaHash == "" {
 		return i.commitHash[:7]
 	}
@@ -92,6 +147,7 @@ type listFlags int
 const (
 	listNames listFlags = 1 << iota
 	listCommit
+	listMeta
 )
 
 func listBuilds(flags listFlags) ([]*buildInfo, error) {
@@ -109,13 +165,16 @@ func listBuilds(flags listFlags) ([]*buildInfo, error) {
 		baseMap = make(map[string]*buildInfo)
 	}
 	for _, file := range files {
-		if !file.IsDir() || !hashPlusRe.MatchString(file.Name()) {
+		if !file.IsDir() || isReservedName(file.Name()) {
 			continue
 		}
-		nameParts := strings.SplitN(file.Name(), "+", 2)
-		info := &buildInfo{commitHash: nameParts[0]}
-		if len(nameParts) > 1 {
-			info.deltaHash = nameParts[1]
+		info := &buildInfo{base: file.Name()}
+		if hashPlusRe.MatchString(file.Name()) {
+			nameParts := strings.SplitN(file.Name(), "+", 2)
+			info.commitHash = nameParts[0]
+			if len(nameParts) > 1 {
+				info.deltaHash = nameParts[1]
+			}
 		}
 
 		builds = append(builds, info)
@@ -123,12 +182,26 @@ func listBuilds(flags listFlags) ([]*buildInfo, error) {
 			baseMap[file.Name()] = info
 		}
 
+		if flags&listMeta != 0 {
+			// Builds saved by older versions of gover don't
+			// record their save time, but the directory's
+			// modification time is a good approximation.
+			info.saveTime = readMeta(filepath.Join(*verDir, file.Name())).SaveTime
+			if info.saveTime.IsZero() {
+				info.saveTime = file.ModTime()
+			}
+		}
+
 		if flags&listCommit != 0 {
-			commit, err := ioutil.ReadFile(filepath.Join(*verDir, file.Name(), "commit"))
-			if err != nil {
+			c, err := readCommit(filepath.Join(*verDir, file.Name()))
+			if os.IsNotExist(err) {
+				// Saved without git. Fall back to the
+				// time the build was saved.
+				info.commit = &commit{authorDate: file.ModTime(), commitDate: file.ModTime()}
+			} else if err != nil {
 				log.Fatal(err)
 			} else {
-				info.commit = parseCommit(commit)
+				info.commit = c
 			}
 		}
 	}
@@ -137,7 +210,7 @@ func listBuilds(flags listFlags) ([]*buildInfo, error) {
 	if flags&listNames != 0 {
 		for _, file := range files {
 			if file.Mode()&os.ModeType == os.ModeSymlink {
-				base, err := os.Readlink(filepath.Join(*verDir, file.Name()))
+				base, err := readLinkBase(filepath.Join(*verDir, file.Name()))
 				if err != nil {
 					continue
 				}
@@ -151,22 +224,112 @@ func listBuilds(flags listFlags) ([]*buildInfo, error) {
 	return builds, nil
 }
 
+// readLinkBase returns the name of the base directory that the name
+// symlink at path refers to. The link may be relative or absolute (see
+// -absolute-links). Either way, only the last element of the target
+// matters, so this works even if the saved build directory has moved.
+func readLinkBase(path string) (string, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(target), nil
+}
+
+// isReservedName returns true if name is reserved for gover's
+// internal use in the saved build directory, such as the
+// deduplication cache.
+func isReservedName(name string) bool {
+	return strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".")
+}
+
 type commit struct {
 	authorDate time.Time
+	commitDate time.Time // committer date, when the commit was made in this tree
 	topLine    string
 }
 
+// commitCacheName is the file in a saved build that caches what
+// readCommit parsed from the build's commit file, so list doesn't
+// have to parse every build's commit each time.
+const commitCacheName = ".meta"
+
+// A commitCache is the contents of commitCacheName.
+type commitCache struct {
+	ModTime    time.Time // of the commit file the rest is from
+	AuthorDate time.Time
+	CommitDate time.Time // zero in caches written before it was added
+	TopLine    string
+}
+
+// readCommit returns the commit saved with the build at savePath,
+// using the cached parse of the commit file if it's up to date.
+func readCommit(savePath string) (*commit, error) {
+	path := filepath.Join(savePath, "commit")
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	cachePath := filepath.Join(savePath, commitCacheName)
+	var cache commitCache
+	if data, err := ioutil.ReadFile(cachePath); err == nil && json.Unmarshal(data, &cache) == nil && cache.ModTime.Equal(st.ModTime()) && !cache.CommitDate.IsZero() {
+		return &commit{authorDate: cache.AuthorDate.Local(), commitDate: cache.CommitDate.Local(), topLine: cache.TopLine}, nil
+	}
+
+	obj, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := parseCommit(obj)
+	// A missing, corrupt, or stale cache is rewritten. The cache is
+	// only an optimization, so ignore errors, such as from a
+	// read-only gover directory.
+	cache = commitCache{st.ModTime(), c.authorDate, c.commitDate, c.topLine}
+	if data, err := json.Marshal(&cache); err == nil {
+		writeCommitCache(savePath, st, data)
+	}
+	return c, nil
+}
+
+// writeCommitCache writes data to the commit cache of the build at
+// savePath if its commit file is still st. The cache is written under
+// the build's lock so it's never left in a build that's being
+// replaced or removed, and replaced atomically so a concurrent reader
+// sees either the old or the new cache.
+func writeCommitCache(savePath string, st os.FileInfo, data []byte) {
+	base, err := filepath.EvalSymlinks(savePath)
+	if err != nil {
+		return
+	}
+	unlock, err := tryLockBuild(filepath.Base(base))
+	if err != nil {
+		return
+	}
+	defer unlock()
+	// The build may have been replaced or removed before it was
+	// locked.
+	if st2, err := os.Stat(filepath.Join(savePath, "commit")); err != nil || !os.SameFile(st, st2) || !st.ModTime().Equal(st2.ModTime()) {
+		return
+	}
+	replaceFile(filepath.Join(savePath, commitCacheName), data, 0666)
+}
+
 func parseCommit(obj []byte) *commit {
 	out := &commit{}
 	lines := strings.Split(string(obj), "\n")
 	for i, line := range lines {
-		if strings.HasPrefix(line, "author ") {
-			fs := strings.Fields(line)
-			secs, err := strconv.ParseInt(fs[len(fs)-2], 10, 64)
-			if err != nil {
-				log.Fatalf("malformed author in commit: %s", err)
+		for _, field := range []struct {
+			prefix string
+			date   *time.Time
+		}{{"author ", &out.authorDate}, {"committer ", &out.commitDate}} {
+			if strings.HasPrefix(line, field.prefix) {
+				fs := strings.Fields(line)
+				secs, err := strconv.ParseInt(fs[len(fs)-2], 10, 64)
+				if err != nil {
+					log.Fatalf("malformed %sin commit: %s", field.prefix, err)
+				}
+				*field.date = time.Unix(secs, 0)
 			}
-			out.authorDate = time.Unix(secs, 0)
 		}
 		if len(line) == 0 {
 			out.topLine = lines[i+1]
@@ -0,0 +1,73 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLinkForms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gover-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldVerDir, oldAbsoluteLinks := *verDir, *absoluteLinks
+	defer func() { *verDir, *absoluteLinks = oldVerDir, oldAbsoluteLinks }()
+
+	*verDir = filepath.Join(dir, "gover")
+	const hash = "0123456789abcdef0123456789abcdef01234567"
+	if err := os.MkdirAll(filepath.Join(*verDir, hash), 0777); err != nil {
+		t.Fatal(err)
+	}
+	*absoluteLinks = false
+	doLink(hash, filepath.Join(*verDir, "rel"))
+	*absoluteLinks = true
+	doLink(hash, filepath.Join(*verDir, "abs"))
+
+	if target, _ := os.Readlink(filepath.Join(*verDir, "rel")); target != hash {
+		t.Errorf("relative link target is %q, want %q", target, hash)
+	}
+	if target, _ := os.Readlink(filepath.Join(*verDir, "abs")); !filepath.IsAbs(target) {
+		t.Errorf("absolute link target %q is not absolute", target)
+	}
+
+	check := func(when string) {
+		builds, err := listBuilds(listNames)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(builds) != 1 {
+			t.Fatalf("%s: got %d builds, want 1", when, len(builds))
+		}
+		if want := []string{"abs", "rel"}; !reflect.DeepEqual(builds[0].names, want) {
+			t.Errorf("%s: got names %v, want %v", when, builds[0].names, want)
+		}
+		for _, name := range []string{"rel", "abs"} {
+			path, ok := resolveName(name)
+			if !ok {
+				t.Errorf("%s: failed to resolve %s", when, name)
+				continue
+			}
+			if st, err := os.Stat(path); err != nil || !st.IsDir() {
+				t.Errorf("%s: %s resolved to %s, which is not a directory", when, name, path)
+			}
+		}
+	}
+	check("before move")
+
+	// Relative links survive moving the saved build directory.
+	// Absolute links dangle, but gover still resolves them.
+	moved := filepath.Join(dir, "moved")
+	if err := os.Rename(*verDir, moved); err != nil {
+		t.Fatal(err)
+	}
+	*verDir = moved
+	check("after move")
+}
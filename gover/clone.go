@@ -0,0 +1,113 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+)
+
+// doClone checks out git revision rev of goroot() in a scratch
+// worktree, builds it, saves it as name, and removes the worktree
+// again. Unlike save -ref, it leaves the current checkout alone.
+//
+// As with save -ref, the build runs in a child gover process so that
+// nothing it does, including exiting with log.Fatal, can skip removing
+// the worktree.
+func doClone(rev, name string) {
+	checkGitGoroot()
+	root := goroot()
+
+	// Resolve everything before changing anything so mistakes
+	// fail harmlessly.
+	commit := strings.TrimSpace(gitCmd("rev-parse", "--verify", rev+"^{commit}"))
+	if path, exists := resolveName(name); exists && filepath.Base(path) != commit {
+		log.Fatalf("name `%s' exists and refers to another build", name)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	scratch, err := ioutil.TempDir("", "gover-clone-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	wt := filepath.Join(scratch, "go")
+	cleanup := func() {
+		if _, stderr, err := runGit([]string{"-C", root, "worktree", "remove", "--force", wt}); err != nil {
+			os.Stderr.Write(stderr)
+		}
+		os.RemoveAll(scratch)
+		// Forget the worktree even if removing it failed
+		// partway.
+		runGit([]string{"-C", root, "worktree", "prune"})
+	}
+	if _, stderr, err := runGit([]string{"-C", root, "worktree", "add", "-q", "--detach", wt, commit}); err != nil {
+		os.Stderr.Write(stderr)
+		cleanup()
+		log.Fatalf("failed to check out %s", rev)
+	}
+
+	// Pass along all flags except -C, which names the worktree
+	// instead.
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "C" || f.Name == "goroot" {
+			return
+		}
+		if l, ok := f.Value.(*stringList); ok {
+			for _, v := range *l {
+				args = append(args, fmt.Sprintf("-%s=%s", f.Name, v))
+			}
+			return
+		}
+		args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+	})
+	args = append(args, "-C", wt, "build", name)
+	c := exec.Command(exe, args...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// An interrupt from the terminal goes to the build too. Outlive
+	// it to remove the worktree.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	done := logCommand(exe, args...)
+	err = c.Run()
+	done(0)
+	signal.Stop(sig)
+
+	cleanup()
+	if ee, ok := err.(*exec.ExitError); ok {
+		os.Exit(ee.ExitCode())
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	recordClonedFrom(resolveBase(name), root)
+}
+
+// recordClonedFrom records in the build at savePath that it was cloned
+// from the Go tree at root. Its binaries still have the scratch
+// worktree, which is gone, built in as their GOROOT, but since that's
+// expected, gover doesn't warn about it every time the build runs.
+func recordClonedFrom(savePath, root string) {
+	unlock, err := lockMeta(filepath.Base(savePath))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer unlock()
+	meta := readMeta(savePath)
+	meta.ClonedFrom = root
+	writeMeta(savePath, meta)
+	if _, err := os.Stat(filepath.Join(savePath, manifestName)); err == nil {
+		updateManifest(savePath, metaName)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+)
+
+var (
+	compress      = flag.String("compress", "gzip", "for export, compress the archive with `codec` gzip, zstd, or none")
+	compressLevel = flag.Int("compress-level", 0, "for export and save -compress-src, compress at `level`, from 1 (fastest) up to 9 for gzip or 19 for zstd (default the codec's own)")
+)
+
+// A codec compresses and decompresses exported builds. Archives are
+// read with whichever codec's magic number they start with, so
+// importing doesn't need to be told how an archive was compressed.
+type codec interface {
+	// compress returns a writer that compresses to w at level, or
+	// at the codec's default level if level is 0. Closing it flushes
+	// the compressed data but doesn't close w.
+	compress(w io.Writer, level int) (io.WriteCloser, error)
+
+	// levels returns the range of levels compress accepts, or 0, 0
+	// if the codec doesn't have levels.
+	levels() (min, max int)
+
+	// decompress returns a reader of the data compressed in r.
+	decompress(r io.Reader) (io.ReadCloser, error)
+
+	// magic returns the bytes the codec's compressed data starts
+	// with, or nil if it doesn't have any.
+	magic() []byte
+}
+
+var codecs = map[string]codec{
+	"gzip": gzipCodec{},
+	"zstd": zstdCodec{},
+	"none": noCodec{},
+}
+
+// lookupCodec returns the codec called name. It exits if there is no
+// such codec, or if -compress-level is outside its range.
+func lookupCodec(name string) codec {
+	c, ok := codecs[name]
+	if !ok {
+		var names []string
+		for name := range codecs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		log.Fatalf("unknown compression `%s'; must be one of %v", name, names)
+	}
+	if *compressLevel != 0 {
+		min, max := c.levels()
+		if max == 0 {
+			log.Fatalf("-compress-level can't be used with -compress %s", name)
+		}
+		if *compressLevel < min || *compressLevel > max {
+			log.Fatalf("bad -compress-level %d for %s; must be from %d to %d", *compressLevel, name, min, max)
+		}
+	}
+	return c
+}
+
+// decompressReader returns a reader of the data in r, decompressed
+// with the codec whose magic number r starts with, if any.
+func decompressReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	for _, c := range codecs {
+		m := c.magic()
+		if m == nil {
+			continue
+		}
+		if prefix, _ := br.Peek(len(m)); bytes.Equal(prefix, m) {
+			return c.decompress(br)
+		}
+	}
+	return ioutil.NopCloser(br), nil
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) compress(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+func (gzipCodec) decompress(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (gzipCodec) levels() (min, max int) { return gzip.BestSpeed, gzip.BestCompression }
+
+func (gzipCodec) magic() []byte { return []byte{0x1f, 0x8b} }
+
+// zstdCodec runs the zstd command, since the standard library doesn't
+// implement zstd.
+type zstdCodec struct{}
+
+func (zstdCodec) compress(w io.Writer, level int) (io.WriteCloser, error) {
+	args := []string{"-q", "-c"}
+	if level != 0 {
+		args = append(args, fmt.Sprintf("-%d", level))
+	}
+	c, err := zstdCmd(args...)
+	if err != nil {
+		return nil, err
+	}
+	c.Stdout = w
+	in, err := c.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	return &cmdPipe{in, c}, nil
+}
+
+func (zstdCodec) decompress(r io.Reader) (io.ReadCloser, error) {
+	c, err := zstdCmd("-d", "-q", "-c")
+	if err != nil {
+		return nil, err
+	}
+	c.Stdin = r
+	out, err := c.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	return &cmdPipe{out, c}, nil
+}
+
+// levels doesn't include zstd's -ultra levels, which need much more
+// memory to decompress.
+func (zstdCodec) levels() (min, max int) { return 1, 19 }
+
+func (zstdCodec) magic() []byte { return []byte{0x28, 0xb5, 0x2f, 0xfd} }
+
+func zstdCmd(args ...string) (*exec.Cmd, error) {
+	path, err := exec.LookPath("zstd")
+	if err != nil {
+		return nil, fmt.Errorf("zstd compression requires the zstd command: %v", err)
+	}
+	c := exec.Command(path, args...)
+	c.Stderr = os.Stderr
+	return c, nil
+}
+
+// A cmdPipe is one end of a pipe to a command. Closing it closes the
+// pipe and waits for the command to exit.
+type cmdPipe struct {
+	pipe io.Closer
+	cmd  *exec.Cmd
+}
+
+func (p *cmdPipe) Read(b []byte) (int, error) {
+	return p.pipe.(io.Reader).Read(b)
+}
+
+func (p *cmdPipe) Write(b []byte) (int, error) {
+	return p.pipe.(io.Writer).Write(b)
+}
+
+func (p *cmdPipe) Close() error {
+	if r, ok := p.pipe.(io.Reader); ok {
+		// Consume any trailing output, such as tar's padding,
+		// so the command doesn't fail writing it.
+		io.Copy(ioutil.Discard, r)
+	}
+	err := p.pipe.Close()
+	if err := p.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %v", p.cmd.Path, err)
+	}
+	return err
+}
+
+type noCodec struct{}
+
+func (noCodec) compress(w io.Writer, level int) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+func (noCodec) decompress(r io.Reader) (io.ReadCloser, error) { return ioutil.NopCloser(r), nil }
+
+func (noCodec) levels() (min, max int) { return 0, 0 }
+
+func (noCodec) magic() []byte { return nil }
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
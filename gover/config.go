@@ -0,0 +1,73 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// config is the contents of gover's configuration file, which is
+// JSON.
+type config struct {
+	// Remotes maps remote names to their locations for push and
+	// pull. A location is either a directory or an ssh location of
+	// the form [user@]host:directory.
+	Remotes map[string]string
+
+	// Hook is the shell command to run after saving a build if
+	// -hook isn't given.
+	Hook string
+
+	// PruneKeep is the number of unnamed builds to keep after
+	// saving a build if -prune-keep isn't given. If it's 0, save
+	// doesn't remove any builds.
+	PruneKeep int
+
+	// PkgTrees lists the directories under pkg to save if
+	// -pkg-tree isn't given, in the same form as -pkg-tree.
+	PkgTrees []string
+}
+
+// configPath returns the path of the configuration file: $GOVER_CONFIG
+// if set, or gover/config.json in the user's configuration directory.
+func configPath() string {
+	if path := os.Getenv("GOVER_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gover", "config.json")
+}
+
+var loadedConfig *config
+
+// loadConfig returns the configuration. It's fine for there to be no
+// configuration file.
+func loadConfig() *config {
+	if loadedConfig != nil {
+		return loadedConfig
+	}
+	loadedConfig = new(config)
+	path := configPath()
+	if path == "" {
+		return loadedConfig
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return loadedConfig
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(data, loadedConfig); err != nil {
+		log.Fatalf("%s: %s", path, err)
+	}
+	return loadedConfig
+}
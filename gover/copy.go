@@ -0,0 +1,50 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func doCopy(src, dst string) {
+	srcPath := resolveBase(src)
+	if dst != filepath.Base(dst) || isReservedName(dst) {
+		log.Fatalf("bad build name `%s'", dst)
+	}
+	dstPath, exists := resolveName(dst)
+	if exists {
+		log.Fatalf("saved build `%s' already exists", dst)
+	}
+
+	// Copy into a temporary directory and move it into place once
+	// it's complete so an interrupted copy doesn't leave a partial
+	// build behind.
+	tmp, err := ioutil.TempDir(*verDir, "_tmp-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	startProgress("copying")
+	curProgress.addTotal(srcPath)
+	cpR(srcPath, tmp)
+	stopProgress()
+
+	meta := readMeta(tmp)
+	meta.CopiedFrom = filepath.Base(srcPath)
+	meta.SaveTime = time.Now()
+	writeMeta(tmp, meta)
+	if _, err := os.Stat(filepath.Join(tmp, manifestName)); err == nil {
+		updateManifest(tmp, metaName)
+	}
+
+	if err := os.Rename(tmp, dstPath); err != nil {
+		os.RemoveAll(tmp)
+		log.Fatal(err)
+	}
+	infof("copied build `%s' to `%s'\n", filepath.Base(srcPath), dst)
+}
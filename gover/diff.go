@@ -0,0 +1,279 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var diffSummary = flag.Bool("summary", false, "for diff and diff-live, list added, deleted, and modified files instead of printing a diff")
+
+func doDiff(nameA, nameB string) {
+	pathA, pathB := resolveBase(nameA), resolveBase(nameB)
+	for _, path := range []string{pathA, pathB} {
+		if readMeta(path).NoSrc {
+			log.Fatalf("build `%s' was saved without its source tree (-no-src), so it can't be diffed", filepath.Base(path))
+		}
+	}
+	srcA, srcB := diffSrc(pathA), diffSrc(pathB)
+
+	if _, err := exec.LookPath(*gitPath); err == nil && !*diffSummary {
+		// Run from the save directory so the file names in
+		// the diff are short.
+		c := exec.Command(*gitPath, "diff", "--no-index", verDirRel(srcA), verDirRel(srcB))
+		c.Dir = *verDir
+		c.Stdout, c.Stderr = os.Stdout, os.Stderr
+		err := c.Run()
+		if err, ok := err.(*exec.ExitError); ok && err.ExitCode() == 1 {
+			// The trees differ.
+			return
+		}
+		if err != nil {
+			log.Fatalf("error executing git diff: %s", err)
+		}
+		return
+	}
+
+	for _, d := range diffTrees(srcA, srcB) {
+		fmt.Printf("%c %s\n", d.op, d.path)
+	}
+}
+
+// doDiffLive reports whether saved build name was saved from the
+// current state of the Go tree in goroot(). If the build and the tree
+// are both in git, this compares the build's commit and diff hash with
+// the tree's, which is fast; otherwise, or with -summary, it compares
+// the source trees file by file. It exits with status 1 if they
+// differ. The tree's hash includes untracked files if the build's
+// does.
+func doDiffLive(name string) {
+	savePath := resolveBase(name)
+	base := filepath.Base(savePath)
+	root := goroot()
+
+	same := true
+	compared := false
+	if hashPlusRe.MatchString(base) && isGitRepo(root) {
+		compared = true
+		live, _ := workHash(false, readMeta(savePath).IncludeUntracked || *includeUntracked)
+		if live != base {
+			same = false
+			fmt.Printf("build `%s' differs from %s:\n", name, root)
+			savedRev, savedDelta := splitHash(base)
+			liveRev, liveDelta := splitHash(live)
+			if savedRev != liveRev {
+				fmt.Printf("  commit: saved %s, live %s%s\n", savedRev[:7], liveRev[:7], commitDistance(savedRev, liveRev))
+			}
+			if savedDelta != liveDelta {
+				fmt.Printf("  uncommitted changes: saved %s, live %s\n", orNone(savedDelta), orNone(liveDelta))
+			}
+		}
+	}
+
+	if !compared || (*diffSummary && !same) {
+		var diffs []treeDiff
+		switch {
+		case !readMeta(savePath).NoSrc:
+			diffs = diffTrees(diffSrc(savePath), filepath.Join(root, "src"))
+		case compared:
+			infof("build `%s' was saved without its source tree (-no-src); not listing changed files\n", base)
+		default:
+			log.Fatalf("build `%s' has neither a git hash nor its source tree (-no-src), so it can't be compared with %s", base, root)
+		}
+		if !compared && len(diffs) > 0 {
+			same = false
+			fmt.Printf("build `%s' differs from %s:\n", name, root)
+		}
+		for _, d := range diffs {
+			fmt.Printf("  %c src/%s\n", d.op, filepath.ToSlash(d.path))
+		}
+	}
+
+	if !same {
+		os.Exit(1)
+	}
+	fmt.Printf("build `%s' matches %s\n", name, root)
+}
+
+// splitHash splits a build hash into its commit hash and diff hash.
+func splitHash(hash string) (rev, delta string) {
+	parts := strings.SplitN(hash, "+", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// commitDistance describes how far commit to is from commit from, like
+// " (3 commits ahead)", or returns "" if git can't tell.
+func commitDistance(from, to string) string {
+	count := func(a, b string) string {
+		out, _, err := runGit([]string{"-C", goroot(), "rev-list", "--count", a + ".." + b})
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(out))
+	}
+	ahead, behind := count(from, to), count(to, from)
+	switch {
+	case ahead == "" || behind == "":
+		return ""
+	case behind == "0":
+		return fmt.Sprintf(" (%s commit(s) ahead)", ahead)
+	case ahead == "0":
+		return fmt.Sprintf(" (%s commit(s) behind)", behind)
+	}
+	return fmt.Sprintf(" (%s commit(s) ahead, %s behind)", ahead, behind)
+}
+
+// diffSrc returns the path of the source tree of the build at
+// savePath, extracting it if it's compressed and following the link
+// to it if it's shared.
+func diffSrc(savePath string) string {
+	src := filepath.Join(extractedRoot(savePath), "src")
+	if shared := sharedSrc(src); shared != "" {
+		return shared
+	}
+	return src
+}
+
+// verDirRel returns path relative to the gover directory if possible.
+func verDirRel(path string) string {
+	dir, err := filepath.Abs(*verDir)
+	if err != nil {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(dir, abs); err == nil {
+		return rel
+	}
+	return path
+}
+
+// doShowDiff prints the uncommitted changes saved with build name.
+func doShowDiff(name string) {
+	savePath := resolveBase(name)
+	staged, err := ioutil.ReadFile(filepath.Join(savePath, "diff.staged"))
+	if err == nil {
+		unstaged, err := ioutil.ReadFile(filepath.Join(savePath, "diff.unstaged"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("# Staged changes\n%s", staged)
+		fmt.Printf("# Unstaged changes\n%s", unstaged)
+		return
+	} else if !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+
+	diff, err := ioutil.ReadFile(filepath.Join(savePath, "diff"))
+	if os.IsNotExist(err) {
+		infof("build `%s' has no uncommitted changes\n", name)
+		return
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(diff)
+}
+
+// resolveBase returns the path of the base directory of saved build
+// name, following name symlinks. It exits if name does not exist.
+func resolveBase(name string) string {
+	savePath, ok := resolveName(name)
+	if !ok {
+		unknownName(name)
+	}
+	savePath, err := filepath.EvalSymlinks(savePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return savePath
+}
+
+type treeDiff struct {
+	op   byte // 'A'dded, 'D'eleted, or 'M'odified
+	path string
+}
+
+// diffTrees compares the files under directories a and b and returns
+// the differences sorted by path.
+func diffTrees(a, b string) []treeDiff {
+	filesA, filesB := treeFiles(a), treeFiles(b)
+	var diffs []treeDiff
+	for path, stA := range filesA {
+		stB, ok := filesB[path]
+		if !ok {
+			diffs = append(diffs, treeDiff{'D', path})
+		} else if !sameContents(filepath.Join(a, path), stA, filepath.Join(b, path), stB) {
+			diffs = append(diffs, treeDiff{'M', path})
+		}
+	}
+	for path := range filesB {
+		if _, ok := filesA[path]; !ok {
+			diffs = append(diffs, treeDiff{'A', path})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].path < diffs[j].path })
+	return diffs
+}
+
+// treeFiles returns the regular files under root, keyed by their path
+// relative to root.
+func treeFiles(root string) map[string]os.FileInfo {
+	files := make(map[string]os.FileInfo)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files[path[len(root)+1:]] = info
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return files
+}
+
+func sameContents(pathA string, stA os.FileInfo, pathB string, stB os.FileInfo) bool {
+	if os.SameFile(stA, stB) {
+		// Deduplicated files are hard links to the same file.
+		return true
+	}
+	if stA.Size() != stB.Size() {
+		return false
+	}
+	hashA, err := hashFile(pathA)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hashB, err := hashFile(pathB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return hashA == hashB
+}
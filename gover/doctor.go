@@ -0,0 +1,133 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A doctorCheck is the result of one environment check.
+type doctorCheck struct {
+	name     string
+	ok       bool
+	severity severity // of the problem, if the check failed
+	detail   string
+	hint     string // how to fix a failed check
+}
+
+func doDoctor() {
+	var checks []doctorCheck
+	var problems problemList
+	pass := func(name, detail string) {
+		checks = append(checks, doctorCheck{name: name, ok: true, detail: detail})
+	}
+	fail := func(sev severity, name, detail, hint string) {
+		checks = append(checks, doctorCheck{name, false, sev, detail, hint})
+		problems.add(problem{Severity: sev, Check: name, Message: detail, Hint: hint})
+	}
+
+	if path, err := exec.LookPath(*gitPath); err != nil {
+		fail(severityError, "git", err.Error(), "install git, add it to $PATH, or pass -git path")
+	} else {
+		pass("git", path)
+	}
+
+	root := *gorootFlag
+	if root == "" {
+		fail(severityError, "Go tree", "not in a Go source tree", "run gover from a git checkout of Go or pass -C dir")
+	} else if !isGitRepo(root) {
+		fail(severityError, "Go tree", root+" is not a git repository", "gover can only save builds from a git checkout of Go")
+	} else {
+		pass("Go tree", root)
+	}
+
+	if root != "" {
+		goBin := filepath.Join(root, "bin", "go")
+		if _, err := os.Stat(goBin); err != nil {
+			fail(severityError, "build", goBin+" does not exist", "run make.bash or use \"gover build\"")
+		} else {
+			pass("build", goBin)
+		}
+	}
+
+	if err := checkWritable(*verDir); err != nil {
+		fail(severityError, "save directory", err.Error(), "fix permissions or pass -dir to use another directory")
+	} else {
+		pass("save directory", *verDir)
+	}
+
+	if root != "" {
+		need, err := estimateSaveSize(root)
+		free, err2 := freeSpace(*verDir)
+		if err == nil {
+			err = err2
+		}
+		if err != nil {
+			fail(severityError, "disk space", err.Error(), "")
+		} else if uint64(need) > free {
+			// Only a warning, since save checks again
+			// before saving, and -force overrides it.
+			fail(severityWarning, "disk space", fmt.Sprintf("%s free, but a save may need up to %s", fmtBytes(int64(free)), fmtBytes(need)), "free up disk space or pass -dir to use another file system")
+		} else {
+			pass("disk space", fmt.Sprintf("%s free", fmtBytes(int64(free))))
+		}
+	}
+
+	if builds, err := listBuilds(0); err == nil {
+		const name = "saved GOROOTs"
+		const hint = "programs that use the GOROOT built into these builds may misbehave; rebuild them or set $GOROOT"
+		var moved []string
+		for _, b := range builds {
+			if root := movedGoroot(filepath.Join(*verDir, b.fullName())); root != "" {
+				moved = append(moved, b.shortName())
+				problems.add(problem{Severity: severityWarning, Build: b.shortName(), Check: name, Message: "saved from " + root + ", which no longer exists", Hint: hint})
+			}
+		}
+		if len(moved) > 0 {
+			checks = append(checks, doctorCheck{name, false, severityWarning, fmt.Sprintf("saved from Go trees that no longer exist: %s", strings.Join(moved, " ")), hint})
+		} else {
+			pass(name, fmt.Sprintf("%d build(s) saved from existing Go trees", len(builds)))
+		}
+	}
+
+	if *jsonOut {
+		problems.printJSON()
+	} else {
+		for _, c := range checks {
+			status := "ok  "
+			switch {
+			case c.ok:
+			case c.severity == severityWarning:
+				status = "WARN"
+			default:
+				status = "FAIL"
+			}
+			fmt.Printf("%s %s: %s\n", status, c.name, c.detail)
+			if !c.ok && c.hint != "" {
+				fmt.Printf("     %s\n", c.hint)
+			}
+		}
+	}
+	problems.exit(1)
+}
+
+// checkWritable returns an error if files cannot be created in dir.
+// It creates dir if necessary.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "_doctor")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
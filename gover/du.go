@@ -0,0 +1,181 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	duTotal     = flag.Bool("total", false, "for du, print only the total size of the saved builds")
+	duOlderThan = flag.String("older-than", "", "for du, report only builds saved more than `age` ago, such as 60d")
+	duNewerThan = flag.String("newer-than", "", "for du, report only builds saved less than `age` ago, such as 2w")
+)
+
+// An inode identifies a file independently of its hard links.
+type inode struct{ dev, ino uint64 }
+
+// An inodeUse records where the links to a file are.
+type inodeUse struct {
+	size           int64
+	nlink          uint64
+	named, unnamed uint64 // links in named and unnamed builds
+	selected       uint64 // links in builds selected by -older-than and -newer-than
+}
+
+// doDu prints how much space each saved build uses and how much of
+// that only it uses, followed by totals for the whole gover
+// directory. Since files are deduplicated, builds generally share
+// most of their space.
+func doDu() {
+	builds, err := listBuilds(listNames | listMeta)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Every build is walked even with -older-than or -newer-than to
+	// find out which files the selected builds share with others.
+	var filters []string
+	selected := func(*buildInfo) bool { return true }
+	for _, f := range []struct {
+		flag, desc string
+		older      bool
+	}{{*duOlderThan, "older than", true}, {*duNewerThan, "newer than", false}} {
+		if f.flag == "" {
+			continue
+		}
+		age, err := parseAge(f.flag)
+		if err != nil {
+			log.Fatalf("bad age %q: %v", f.flag, err)
+		}
+		cutoff, older, prev := time.Now().Add(-age), f.older, selected
+		selected = func(b *buildInfo) bool {
+			return prev(b) && b.saveTime.Before(cutoff) == older
+		}
+		filters = append(filters, f.desc+" "+f.flag)
+	}
+
+	uses := make(map[inode]*inodeUse)
+	// walk calls f for each link to a regular file under path.
+	walk := func(path string, f func(ino inode, use *inodeUse)) {
+		err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			st, ok := info.Sys().(*syscall.Stat_t)
+			if !ok || !info.Mode().IsRegular() {
+				return nil
+			}
+			ino := inode{uint64(st.Dev), uint64(st.Ino)}
+			use := uses[ino]
+			if use == nil {
+				use = &inodeUse{size: info.Size(), nlink: uint64(st.Nlink)}
+				uses[ino] = use
+			}
+			f(ino, use)
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	var named, unnamed, nselected int
+	for _, b := range builds {
+		sel := selected(b)
+		var size int64
+		links := make(map[inode]uint64)
+		walk(filepath.Join(*verDir, b.fullName()), func(ino inode, use *inodeUse) {
+			if links[ino] == 0 {
+				size += use.size
+			}
+			links[ino]++
+			if len(b.names) > 0 {
+				use.named++
+			} else {
+				use.unnamed++
+			}
+			if sel {
+				use.selected++
+			}
+		})
+		if len(b.names) > 0 {
+			named++
+		} else {
+			unnamed++
+		}
+		if !sel {
+			continue
+		}
+		nselected++
+		if *duTotal {
+			continue
+		}
+		var unique int64
+		for ino, n := range links {
+			if use := uses[ino]; !sharedOutside(use.nlink, n) {
+				unique += use.size
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s unique\t%s", fmtBytes(size), fmtBytes(unique), b.shortName())
+		if len(b.names) > 0 {
+			fmt.Fprintf(w, " %s", b.names)
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+
+	// Count everything else in the gover directory, like the
+	// deduplication cache, in the total.
+	files, err := ioutil.ReadDir(*verDir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	for _, file := range files {
+		if file.IsDir() && isReservedName(file.Name()) {
+			walk(filepath.Join(*verDir, file.Name()), func(inode, *inodeUse) {})
+		}
+	}
+	var total, namedOnly, unnamedOnly, selectedOnly int64
+	for _, use := range uses {
+		total += use.size
+		// A file used only by one kind of build is freed by
+		// removing those builds and running gc.
+		if use.unnamed == 0 && use.named > 0 && !sharedOutside(use.nlink, use.named) {
+			namedOnly += use.size
+		}
+		if use.named == 0 && use.unnamed > 0 && !sharedOutside(use.nlink, use.unnamed) {
+			unnamedOnly += use.size
+		}
+		if use.selected > 0 && !sharedOutside(use.nlink, use.selected) {
+			selectedOnly += use.size
+		}
+	}
+	if !*duTotal && nselected > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("%d saves, %s\n", len(builds), fmtBytes(total))
+	fmt.Printf("  %d named, %s used only by them\n", named, fmtBytes(namedOnly))
+	fmt.Printf("  %d unnamed, %s used only by them\n", unnamed, fmtBytes(unnamedOnly))
+	if len(filters) > 0 {
+		fmt.Printf("  %d saved %s, %s used only by them\n", nselected, strings.Join(filters, " and "), fmtBytes(selectedOnly))
+	}
+}
+
+// sharedOutside reports whether a file with nlink links, n of which
+// are in a set of builds, has links elsewhere, not counting its link
+// in the deduplication cache.
+func sharedOutside(nlink, n uint64) bool {
+	return nlink-n > 1
+}
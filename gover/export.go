@@ -0,0 +1,382 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha1"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A saved build is exported as a tar archive of its directory. Every
+// entry in the archive is under a single top-level directory named
+// after the build's base, so the archive says what it contains and
+// can be unpacked directly into a gover directory with tar(1).
+
+var (
+	exportDocker = flag.Bool("docker", false, "for export, write an archive for \"docker import\" with the build at -docker-root")
+	dereference  = flag.Bool("dereference", false, "for export, archive the files symlinks refer to instead of the symlinks")
+	dockerRoot   = flag.String("docker-root", "/usr/local/go", "for export -docker, the `path` of the build in the image")
+)
+
+// metaFiles are the files gover records in a saved build in addition
+// to the Go tree itself.
+var metaFiles = []string{"commit", commitCacheName, "diff", "diff.staged", "diff.unstaged", metaName, manifestName}
+
+// writeTar writes the tree at root to w as a tar archive with every
+// entry under prefix. If skip is not nil, it omits the files and
+// directories for which skip returns true, given their slash-separated
+// path relative to root.
+func writeTar(w io.Writer, root, prefix string, skip func(rel string) bool) error {
+	return writeTarTrees(w, prefix, tarTree{root, prefix, skip})
+}
+
+// A tarTree is a tree for writeTarTrees to archive, as for writeTar.
+type tarTree struct {
+	root, prefix string
+	skip         func(rel string) bool
+}
+
+// writeTarTrees writes trees to w as a single tar archive, after the
+// parent directories of prefix.
+func writeTarTrees(w io.Writer, prefix string, trees ...tarTree) error {
+	tw := tar.NewWriter(w)
+	// Create prefix's parent directories.
+	var parents []string
+	for dir := path.Dir(prefix); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		parents = append([]string{dir}, parents...)
+	}
+	for _, dir := range parents {
+		hdr := &tar.Header{Name: dir + "/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+	}
+	for _, t := range trees {
+		if err := writeTarTree(tw, t.root, t.prefix, t.skip); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// writeTarTree writes the files under root to tw as prefix.
+func writeTarTree(tw *tar.Writer, root, prefix string, skip func(rel string) bool) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if skip != nil && rel != "." && skip(filepath.ToSlash(rel)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		var link string
+		if shared := sharedSrc(p); shared != "" && info.Mode()&os.ModeSymlink != 0 {
+			// Archive the contents of a shared source
+			// tree, since the archive won't have it.
+			return writeTarTree(tw, shared, path.Join(prefix, filepath.ToSlash(rel)), nil)
+		}
+		if info.Mode()&os.ModeSymlink != 0 && *dereference {
+			// Archive what the link refers to instead.
+			target, err := filepath.EvalSymlinks(p)
+			if err != nil {
+				return err
+			}
+			if info, err = os.Stat(target); err != nil {
+				return err
+			}
+			if info.IsDir() {
+				abs, err := filepath.Abs(p)
+				if err != nil {
+					return err
+				}
+				if target, err = filepath.Abs(target); err != nil {
+					return err
+				}
+				if strings.HasPrefix(abs, target+string(filepath.Separator)) {
+					return fmt.Errorf("symlink loop at %s", p)
+				}
+				return writeTarTree(tw, target, path.Join(prefix, filepath.ToSlash(rel)), nil)
+			}
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(prefix, filepath.ToSlash(rel))
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		// Don't leak local users into the archive.
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		curProgress.add(info.Size())
+		return err
+	})
+}
+
+// readTar unpacks an exported build from r into dir and returns the
+// build's base, which is the archive's top-level directory. The
+// archive may be compressed with any codec. Files and directories get
+// the modification times recorded in the archive. If dedup isn't
+// empty, regular files are deduplicated against the dedup cache at
+// dedup, as saving does.
+func readTar(r io.Reader, dir, dedup string) (base string, err error) {
+	dr, err := decompressReader(r)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if cerr := dr.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	tr := tar.NewReader(dr)
+	var dirs copiedDirs
+	// links records the symlinks unpacked so far. Nothing may be
+	// unpacked under one, or hard linked through one, since it may
+	// point outside dir.
+	links := make(map[string]bool)
+	// files records the regular files unpacked so far, which are
+	// the only files a hard link may refer to.
+	files := make(map[string]bool)
+	underLink := func(name string) string {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if links[dir] {
+				return dir
+			}
+		}
+		return ""
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return "", fmt.Errorf("bad path in archive: %s", hdr.Name)
+		}
+		top := strings.SplitN(name, "/", 2)[0]
+		if base == "" {
+			if isReservedName(top) {
+				return "", fmt.Errorf("bad build name in archive: %s", top)
+			}
+			base = top
+		} else if top != base {
+			return "", fmt.Errorf("archive contains more than one build: %s and %s", base, top)
+		}
+		if dir := underLink(name); dir != "" {
+			return "", fmt.Errorf("bad path in archive: %s is under symlink %s", hdr.Name, dir)
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = dirs.mkdir(target, hdr.FileInfo())
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeTarFile(target, tr, mode, hdr.ModTime, dedup)
+			files[name] = true
+		case tar.TypeSymlink:
+			err = os.Symlink(hdr.Linkname, target)
+			links[name] = true
+		case tar.TypeLink:
+			// tar(1) records files that are hard linked
+			// together, such as deduplicated files, as links
+			// to the first copy.
+			old := path.Clean(strings.TrimPrefix(hdr.Linkname, "./"))
+			if !files[old] || underLink(old) != "" {
+				return "", fmt.Errorf("bad link in archive: %s is not a file unpacked before %s", hdr.Linkname, hdr.Name)
+			}
+			err = os.Link(filepath.Join(dir, filepath.FromSlash(old)), target)
+			files[name] = true
+		default:
+			err = fmt.Errorf("unsupported file type in archive: %s", hdr.Name)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if base == "" {
+		return "", fmt.Errorf("empty archive")
+	}
+	if err := dirs.finish(); err != nil {
+		return "", err
+	}
+	return base, nil
+}
+
+// writeTarFile writes the file at path from r with mode and
+// modification time mtime. If dedup isn't empty, the file then
+// becomes a hard link to the matching file in the dedup cache at
+// dedup, which it's added to if it isn't there yet.
+func writeTarFile(path string, r io.Reader, mode os.FileMode, mtime time.Time, dedup string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	h := sha1.New()
+	_, err = io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chtimes(path, mtime, mtime)
+	}
+	if err != nil || dedup == "" {
+		return err
+	}
+
+	hash := fmt.Sprintf("%x", h.Sum(nil))
+	xpath := filepath.Join(dedup, hash[:2], hash[2:])
+	if _, err := os.Stat(xpath); err == nil {
+		// Replace the file with the cached copy.
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		return os.Link(xpath, path)
+	}
+	if err := os.MkdirAll(filepath.Dir(xpath), 0777); err != nil {
+		return err
+	}
+	return os.Link(path, xpath)
+}
+
+// importTar unpacks an exported build from r into the gover directory
+// dir and returns its base. If dir already has the build, it returns
+// false and leaves the existing build alone.
+func importTar(r io.Reader, dir string) (string, bool, error) {
+	// Unpack into a temporary directory and move the build into
+	// place once it's complete so an interrupted import doesn't
+	// leave a partial build behind.
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", false, err
+	}
+	tmp, err := ioutil.TempDir(dir, "_tmp-")
+	if err != nil {
+		return "", false, err
+	}
+	defer os.RemoveAll(tmp)
+	dedup := filepath.Join(dir, "_dedup")
+	if *noDedup {
+		dedup = ""
+	}
+	base, err := readTar(r, tmp, dedup)
+	if err != nil {
+		return "", false, err
+	}
+	if _, err := os.Lstat(filepath.Join(dir, base)); err == nil {
+		return base, false, nil
+	}
+	if err := os.Rename(filepath.Join(tmp, base), filepath.Join(dir, base)); err != nil {
+		return "", false, err
+	}
+	return base, true, nil
+}
+
+func doExport(name, file string) {
+	savePath := resolveBase(name)
+	c := lookupCodec(*compress)
+	f := os.Stdout
+	if file != "-" {
+		var err error
+		if f, err = os.Create(file); err != nil {
+			log.Fatal(err)
+		}
+	}
+	startProgress("exporting")
+	curProgress.addTotal(savePath)
+	prefix := filepath.Base(savePath)
+	trees := []tarTree{{savePath, prefix, nil}}
+	if *exportDocker {
+		// Lay out the archive as a file system image with just
+		// the Go tree.
+		prefix = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(*dockerRoot)), "/")
+		skipped := metaFiles
+		if hasCompressedSrc(savePath) {
+			// The image's GOROOT needs the source tree
+			// itself, not an archive of it.
+			skipped = append(append([]string{}, metaFiles...), compressedSrcName)
+		}
+		trees = []tarTree{{savePath, prefix, func(rel string) bool { return contains(skipped, rel) }}}
+		if hasCompressedSrc(savePath) {
+			trees = append(trees, tarTree{filepath.Join(extractedRoot(savePath), "src"), path.Join(prefix, "src"), nil})
+		}
+	}
+	cw, err := c.compress(f, *compressLevel)
+	if err == nil {
+		err = writeTarTrees(cw, prefix, trees...)
+		if cerr := cw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	stopProgress()
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		if file != "-" {
+			os.Remove(file)
+		}
+		log.Fatal(err)
+	}
+}
+
+func doImport(file string) {
+	r := io.Reader(os.Stdin)
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+	}
+	base, ok, err := importTar(r, *verDir)
+	if err != nil {
+		log.Fatal(err)
+	} else if !ok {
+		log.Fatalf("saved build `%s' already exists", base)
+	}
+	infof("imported build `%s'\n", base)
+}
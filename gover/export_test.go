@@ -0,0 +1,74 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// TestReadTarBadLinks checks that an archive can't hard link a file
+// from outside the directory it's unpacked into.
+func TestReadTarBadLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gover-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outside := filepath.Join(dir, "outside")
+	if err := os.MkdirAll(outside, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		hdrs []*tar.Header
+	}{
+		{"through symlink", []*tar.Header{
+			{Name: "b/", Typeflag: tar.TypeDir, Mode: 0777},
+			{Name: "b/x", Typeflag: tar.TypeSymlink, Linkname: outside},
+			{Name: "b/y", Typeflag: tar.TypeLink, Linkname: "b/x/secret"},
+		}},
+		{"to symlink", []*tar.Header{
+			{Name: "b/", Typeflag: tar.TypeDir, Mode: 0777},
+			{Name: "b/x", Typeflag: tar.TypeSymlink, Linkname: filepath.Join(outside, "secret")},
+			{Name: "b/y", Typeflag: tar.TypeLink, Linkname: "b/x"},
+		}},
+		{"not unpacked", []*tar.Header{
+			{Name: "b/", Typeflag: tar.TypeDir, Mode: 0777},
+			{Name: "b/y", Typeflag: tar.TypeLink, Linkname: "b/z"},
+		}},
+	}
+	for i, test := range tests {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range test.hdrs {
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		into := filepath.Join(dir, "into", strconv.Itoa(i))
+		if err := os.MkdirAll(into, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readTar(&buf, into, ""); err == nil {
+			t.Errorf("%s: readTar succeeded, want error", test.name)
+		}
+		if _, err := os.Lstat(filepath.Join(into, "b", "y")); err == nil {
+			t.Errorf("%s: readTar created b/y", test.name)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+var compressSrc = flag.Bool("compress-src", false, "for save and build, store the source tree as an archive compressed with -compress")
+
+// With -compress-src, a build's source tree is saved as the archive
+// src.tar instead of as src. Commands that need the whole Go tree use
+// a copy of the build with src unpacked under _extracted, which is
+// created the first time it's needed and then reused until
+// clean-cache removes it.
+const (
+	compressedSrcName = "src.tar"
+	extractedDir      = "_extracted"
+)
+
+// saveCompressedSrc saves the source tree at src as an archive in the
+// build being saved at savePath.
+func saveCompressedSrc(src, savePath string) {
+	c := lookupCodec(*compress)
+	if err := os.MkdirAll(savePath, 0777); err != nil {
+		log.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(savePath, compressedSrcName))
+	if err != nil {
+		log.Fatal(err)
+	}
+	cw, err := c.compress(f, *compressLevel)
+	if err == nil {
+		var skip func(string) bool
+		if saveBoundary != nil {
+			skip = func(rel string) bool {
+				path := filepath.Join(src, filepath.FromSlash(rel))
+				info, err := os.Lstat(path)
+				return err == nil && saveBoundary.crosses(path, info)
+			}
+		}
+		err = writeTar(cw, src, "src", skip)
+		if cerr := cw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// hasCompressedSrc returns whether the build at savePath was saved
+// with -compress-src.
+func hasCompressedSrc(savePath string) bool {
+	_, err := os.Stat(filepath.Join(savePath, compressedSrcName))
+	return err == nil
+}
+
+// extractedRoot returns the root of a complete Go tree for the build
+// at savePath. That's savePath itself unless the build has a
+// compressed source tree, in which case it's an absolute path under
+// _extracted, which extractedRoot creates if necessary.
+func extractedRoot(savePath string) string {
+	if !hasCompressedSrc(savePath) {
+		return savePath
+	}
+	base, err := filepath.EvalSymlinks(savePath)
+	if err == nil {
+		base, err = filepath.Abs(base)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	dir, err := filepath.Abs(filepath.Join(*verDir, extractedDir))
+	if err != nil {
+		log.Fatal(err)
+	}
+	root := filepath.Join(dir, filepath.Base(base))
+	if _, err := os.Stat(root); err == nil {
+		return root
+	}
+
+	// Assemble the tree in a temporary directory so an interrupted
+	// extraction isn't reused later.
+	infof("extracting the source tree of `%s'\n", filepath.Base(base))
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		log.Fatal(err)
+	}
+	tmp, err := ioutil.TempDir(*verDir, "_tmp-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Chmod(tmp, 0755); err != nil {
+		log.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(base)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, file := range files {
+		// Link to everything else in the build rather than
+		// copying it.
+		if file.Name() == compressedSrcName {
+			continue
+		}
+		if err := os.Symlink(filepath.Join(base, file.Name()), filepath.Join(tmp, file.Name())); err != nil {
+			log.Fatal(err)
+		}
+	}
+	f, err := os.Open(filepath.Join(base, compressedSrcName))
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = readTar(f, tmp, "")
+	f.Close()
+	if err != nil {
+		log.Fatalf("failed to extract the source tree of `%s': %v", filepath.Base(base), err)
+	}
+	if err := os.Rename(tmp, root); err != nil {
+		// Another command may have just extracted it.
+		if _, err := os.Stat(root); err != nil {
+			log.Fatal(err)
+		}
+	}
+	return root
+}
+
+// doCleanCache removes the extracted copies of compressed source
+// trees.
+func doCleanCache() {
+	dir := filepath.Join(*verDir, extractedDir)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		infof("no extracted source trees\n")
+		return
+	}
+	size, err := treeSize(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Fatal(err)
+	}
+	infof("removed extracted source trees, reclaiming %s\n", fmtBytes(size))
+}
@@ -0,0 +1,144 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+var oneFilesystem = flag.Bool("one-filesystem", false, "for save and build, skip files and directories on a different file system than the Go tree, such as mount points")
+
+// replaceFile writes data to path by writing a temporary file and
+// renaming it over path. Files in saved builds may be hard links into
+// the deduplication cache, so they must be replaced rather than
+// modified in place.
+func replaceFile(path string, data []byte, perm os.FileMode) error {
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// treeSize returns the total size in bytes of the regular files under
+// path. A path that does not exist has size 0.
+func treeSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// freeSpace returns the number of bytes available to unprivileged
+// users on the file system containing path. If path does not exist
+// yet, it uses the nearest parent that does.
+func freeSpace(path string) (uint64, error) {
+	for {
+		var st syscall.Statfs_t
+		err := syscall.Statfs(path, &st)
+		if err == nil {
+			return uint64(st.Bavail) * uint64(st.Bsize), nil
+		}
+		parent := filepath.Dir(path)
+		if err != syscall.ENOENT || parent == path {
+			return 0, &os.PathError{Op: "statfs", Path: path, Err: err}
+		}
+		path = parent
+	}
+}
+
+// An fsBoundary is the file system a save with -one-filesystem
+// stays on.
+type fsBoundary struct {
+	root string
+	dev  uint64
+}
+
+// saveBoundary is the fsBoundary of the save in progress, or nil
+// without -one-filesystem.
+var saveBoundary *fsBoundary
+
+// newFSBoundary returns an fsBoundary for the file system of root.
+func newFSBoundary(root string) *fsBoundary {
+	info, err := os.Stat(root)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dev, ok := fileDevice(info)
+	if !ok {
+		log.Fatalf("-one-filesystem: can't tell which file system %s is on", root)
+	}
+	return &fsBoundary{root, dev}
+}
+
+// crosses reports whether the file at path, described by info from
+// Lstat, is on a different file system than b, and notes it if so.
+// A nil b is never crossed.
+func (b *fsBoundary) crosses(path string, info os.FileInfo) bool {
+	if b.contains(info) {
+		return false
+	}
+	infof("skipping %s, which is on a different file system than %s (-one-filesystem)\n", path, b.root)
+	return true
+}
+
+// contains reports whether the file described by info is on the file
+// system of b. Everything is within a nil b.
+func (b *fsBoundary) contains(info os.FileInfo) bool {
+	if b == nil {
+		return true
+	}
+	dev, ok := fileDevice(info)
+	return !ok || dev == b.dev
+}
+
+// fileDevice returns the device number of the file system info is on.
+func fileDevice(info os.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
+
+// fmtBytes formats a byte count for humans.
+func fmtBytes(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	f, i := float64(n)/1024, 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", f, units[i])
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gitHookMarker identifies git hooks installed by install-hook, so
+// uninstall-hook doesn't remove anyone else's.
+const gitHookMarker = "# Installed by \"gover install-hook\"."
+
+// gitHookPath returns the path of the post-checkout hook for goroot().
+func gitHookPath() string {
+	checkGitGoroot()
+	// Ask git, since the hooks may be in core.hooksPath or, for a
+	// linked worktree, the main repository.
+	dir := strings.TrimSpace(gitCmd("rev-parse", "--git-path", "hooks"))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(goroot(), dir)
+	}
+	return filepath.Join(dir, "post-checkout")
+}
+
+// doInstallHook installs a post-checkout hook in goroot() that builds
+// and saves each commit that's checked out, unless it's already saved.
+func doInstallHook() {
+	path := gitHookPath()
+	if data, err := ioutil.ReadFile(path); err == nil && !strings.Contains(string(data), gitHookMarker) {
+		log.Fatalf("%s already exists; add gover to it yourself or remove it", path)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	dir, err := filepath.Abs(*verDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hook := fmt.Sprintf(`#!/bin/sh
+%s
+# Remove it with "gover uninstall-hook".
+#
+# Build and save each commit that's checked out, unless it's already
+# saved. $3 is 1 for checking out a commit rather than files.
+[ "$3" = 1 ] && [ "$1" != "$2" ] || exit 0
+%s -dir %s -C "$(git rev-parse --show-toplevel)" -q build || echo "gover: failed to save build" >&2
+`, gitHookMarker, shellEscape(exe), shellEscape(dir))
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(hook), 0777); err != nil {
+		log.Fatal(err)
+	}
+	// WriteFile doesn't change the mode of an existing file.
+	if err := os.Chmod(path, 0755); err != nil {
+		log.Fatal(err)
+	}
+	infof("installed %s\n", path)
+}
+
+// doUninstallHook removes the hook installed by doInstallHook.
+func doUninstallHook() {
+	path := gitHookPath()
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		infof("no post-checkout hook installed\n")
+		return
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if !strings.Contains(string(data), gitHookMarker) {
+		log.Fatalf("%s wasn't installed by gover; not removing it", path)
+	}
+	if err := os.Remove(path); err != nil {
+		log.Fatal(err)
+	}
+	infof("removed %s\n", path)
+}
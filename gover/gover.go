@@ -24,41 +24,629 @@
 //     gover [flags] save [name]
 //
 // Save current build under it's commit hash and, optionally, as
-// "name".
+// "name". With -hash, save it under the given name instead of the
+// commit hash. This works for Go trees that aren't git checkouts.
+//
+// save saves the Go tree containing the current directory, or with
+// -C dir, or its alias -goroot dir, the Go tree at dir. The Go that
+// gover itself was built with doesn't matter. This can save a tree
+// built in another directory, or with -hash, an unpacked binary
+// release. The git commands save runs to name the build also run in
+// that tree.
+//
+// If the tree has uncommitted changes, the build is also named after
+// a hash of "git diff HEAD" and the diff is saved with the build. Since
+// git diff ignores untracked files, save warns about them unless
+// -include-untracked is passed, in which case it adds them to the
+// diff. With -split-diff, save additionally records the staged and
+// unstaged changes separately. Since saving uncommitted changes may be
+// a mistake, save warns about them unless -dirty is passed. With
+// -no-dirty, save refuses to save a tree with uncommitted changes.
+//
+// In addition to the Go tree's binaries, packages, and source, save
+// saves each path given by -include, which is relative to the root of
+// the Go tree and may be repeated. This is useful for Go trees with
+// nonstandard contents, such as extra tools in misc. -with-misc is
+// shorthand for -include misc, which is needed to run tests such as
+// the cgo tests in misc/cgo with a saved build. With -no-src,
+// save doesn't save the source tree, which is usually most of a
+// build's size. Such a build can still compile code with Go versions
+// that install standard library packages in pkg, which Go 1.20 and
+// later don't, but can't be diffed.
+//
+// With -no-commit, save doesn't save the git commit object, which
+// "git cat-file" reads and list uses for each build's date and
+// message. The build is still named after its commit, but list shows
+// when it was saved and no message, as for builds saved with -hash.
+//
+// By default, save saves the directories pkg/GOOS_GOARCH,
+// pkg/tool/GOOS_GOARCH, and pkg/include, where GOOS_GOARCH is the
+// target given by $GOOS and $GOARCH. If -pkg-tree is given, which may
+// be repeated, or PkgTrees in the configuration file, save saves the
+// given directories under pkg instead, with GOOS_GOARCH replaced the
+// same way. For example, -pkg-tree GOOS_GOARCH -pkg-tree
+// tool/GOOS_GOARCH -pkg-tree linux_arm64 also saves the packages of
+// another target but skips pkg/include. Directories that don't exist,
+// like pkg/include in Go versions without C headers, are skipped; -v
+// notes each one.
+//
+// With -name-template, save names a build saved without a name by
+// executing a Go template. The template is executed with a value that
+// has the following fields:
+//
+//     .Branch  current git branch, with / replaced by -; empty if detached
+//     .Date    current date, as 2006-01-02
+//     .Time    current time, as a time.Time
+//     .Hash    the build's base, which it would be named otherwise
+//     .Short   the build's short name, as printed by list
+//     .Dirty   whether the tree had uncommitted changes
+//
+// For example, -name-template '{{.Branch}}-{{.Date}}'.
+//
+// With -name-from-branch, save names a build saved without a name
+// after the current git branch, with / replaced by -, unless HEAD is
+// detached. Since the name is meant to refer to the branch's latest
+// build, it moves from any build it already names.
+//
+// With -tag-latest, save also points the name "latest" at the build,
+// moving it from the previous one, for tools that need a real symlink
+// rather than the latest pseudo-name. Since it moves with every save,
+// it doesn't keep a build from being removed by -prune-keep or prune
+// the way other names do.
+//
+// With -against name, save hard links each file that has the same
+// size, mode, and modification time as the same file in saved build
+// name, instead of reading and copying it. This makes saving a tree
+// that's close to an existing build, such as -against latest, much
+// faster.
+//
+// With -goflags flags, such as -goflags=-mod=mod, save records flags
+// with the build, and run, with, "gover <name> <args>", and env set
+// GOFLAGS to them, in place of any GOFLAGS in the environment, so the
+// build runs the way it was meant to even long after it was saved.
+// info shows the recorded flags. Passing -goflags to those commands
+// uses its flags instead, and -goflags= runs with an empty GOFLAGS.
+//
+// With -share-src, save stores the source tree once for all builds
+// with identical source trees, such as builds of the same commit with
+// different environments, and makes the build's src a symlink to it.
+//
+// With -compress-src, save stores the source tree as an archive
+// compressed with -compress and -compress-level instead. Running a
+// command with the build unpacks the source tree the first time it's
+// needed and reuses it after that.
+//
+// With -one-filesystem, save skips anything in the Go tree that's on a
+// different file system than the tree itself, such as a directory
+// bind-mounted into an overlay GOROOT, and notes what it skipped.
+// Without it, save copies whatever is mounted in the tree.
+//
+// save deduplicates every file it saves against the files of all other
+// saved builds by hard-linking identical files together, so an
+// unchanged gofmt or package costs no space. With -no-dedup, it copies
+// everything instead, unless -dedupe-bin is also given, which keeps
+// deduplicating the binaries in bin, which are often identical from
+// one build to the next even when go changes. Either way, apply and
+// rebuild replace files rather than writing into them, so a shared file
+// is never changed through one build.
+//
+// With -overwrite-base, save and build replace the saved build if the
+// tree was already saved, instead of failing or doing nothing. The new
+// build is saved next to the old one and then swapped in, so no files
+// from the old build linger. This fails if a command is running with
+// the old build.
+//
+// With -prune-keep n, or PruneKeep in the configuration file, save
+// then removes all but the n most recently saved unnamed builds, so
+// the saved builds work as a rolling cache. Named builds are always
+// kept, and so are builds in use by a command run with them, which
+// are reported as errors. Run gc afterward to free the space they
+// used.
+//
+// Before copying anything, save checks that the build will fit in the
+// free space on the file system containing the saved builds, which
+// -force overrides. Since this assumes nothing is deduplicated, it may
+// be overly cautious.
+//
+// With -stat, save then prints how many bytes it saved from bin, pkg,
+// pkg/tool, src, and any -include paths, before deduplication or
+// compression, to show which of -no-src, -share-src, or -compress-src
+// would help most. With -dry-run, save prints the same summary for
+// what it would save, and the builds -prune-keep would remove now,
+// without saving or removing anything.
+//
+// After saving, save runs the shell command given by -hook, or by Hook
+// in the configuration file, with $GOVER_SAVE_PATH set to the saved
+// build's directory and $GOVER_SAVE_NAME set to its name, or its hash
+// if it has no name.
+//
+// When standard error is a terminal and -v isn't set, save, copy,
+// export, and push display how many files and bytes they've copied.
 //
 //     gover [flags] build [name]
 //
 // Like "save", but first run make.bash in the current tree.
 //
+// With -ref rev, save and build both check out git revision rev,
+// build it, and save it, and then check out the original revision
+// again. Uncommitted changes are stashed while rev is checked out and
+// restored afterwards, even if the build fails. Since bin and pkg
+// aren't tracked by git, they're left with the build of rev.
+//
+// With -ref rev -diff file, also apply the diff in file to rev
+// before building, so a build saved with uncommitted changes can be
+// reproduced from its commit and diff, as printed by "diff <name>",
+// without copying the build itself. If file is "-", the diff is read
+// from stdin. The diff must apply cleanly.
+//
+//     gover [flags] apply <name>
+//
+// Replace the binaries, packages, and source tree of the current Go
+// tree with those of saved build <name>, for example to get back a
+// build without rebuilding it. A compressed source tree is unpacked
+// directly into the Go tree. The git checkout itself isn't changed,
+// so git status shows the build's changes from the current commit.
+// Since apply overwrites the source tree, it refuses to if the tree
+// has uncommitted changes, unless -force is given.
+//
+//     gover [flags] mount <name> <dir>
+//     gover [flags] umount <dir>
+//
+// Present saved build <name> as a Go tree at <dir>, which must be empty
+// or not exist, for pointing an IDE or build system at it. Since gover
+// only uses the standard library, it has no FUSE support, so mount
+// copies the build's binaries, packages, and source tree to <dir>,
+// unpacking a compressed source tree, and notes that it did. Changes
+// made in <dir> don't affect the saved build. umount removes a tree
+// made by mount, and refuses to remove anything else.
+//
+//     gover [flags] rebuild <name>
+//
+// Rebuild saved build <name> from its recorded commit and diff in a
+// scratch git worktree of the current tree, and replace the build's
+// binaries and packages with the result. This is useful if they were
+// damaged. The build is rebuilt with the environment it was saved
+// with, and only the package trees it already has, for the GOOS and
+// GOARCH it was saved for, are replaced. The build's saved source
+// tree is left as it is. Since the worktree is removed afterward, the
+// new binaries' built-in GOROOT no longer exists; see doctor.
+//
+//     gover [flags] clone <rev> <name>
+//
+// Check out git revision <rev> of the current tree in a scratch git
+// worktree, build it, save it as <name>, and remove the worktree. This
+// is like "gover -ref <rev> build <name>", except that it leaves the
+// current checkout and its uncommitted changes alone, so there's
+// nothing to restore if the build fails or is interrupted. The
+// worktree is gone afterward, so programs that use the GOROOT built
+// into the build, rather than $GOROOT, may misbehave. Since that's
+// expected, gover doesn't warn about it for cloned builds.
+//
 //     gover [flags] <name> <args>...
 //
 // Run "go <args>..." using saved build <name>. <name> may be an
-// unambiguous commit hash or an explicit build name.
+// unambiguous commit hash, an explicit build name, "latest" for the
+// build with the most recent commit date, or "oldest" (or "first")
+// for the build with the earliest commit date. If a hash prefix
+// matches more than one build and gover is running on a terminal, it
+// asks which one to use.
+//
+//     gover [flags] latest|oldest|first
+//
+// Print the saved build with the most recent or earliest commit date.
 //
 //     gover [flags] with <name> <command>...
 //
-// Run <command> with PATH and GOROOT for build <name>.
+// Run <command> with PATH and GOROOT for build <name>. With -workdir
+// dir, run <command> in dir, like "go -C dir" or "make -C dir". This
+// also applies when running go with "gover <name> <args>". With
+// -timeout d, kill <command> if it runs longer than d, and exit with
+// status 124. Unless standard input is a terminal, the processes
+// <command> started are killed too. With -out file, write the
+// output of <command> to file instead, where %n in file is replaced
+// with <name>. With -tee, also print the output. With -restore-env,
+// set the environment variables that affect the Go build, such as
+// GOEXPERIMENT and CGO_ENABLED, to what they were when <name> was
+// saved.
+//
+// With -tty, run <command> in a new pseudo-terminal, so interactive
+// programs, like debuggers, behave as they would in a terminal even if
+// gover's output is redirected, as with -out. The command's standard
+// output and error are then combined. -tty is only supported on Linux;
+// elsewhere, gover warns and runs <command> without one.
+//
+// If <command> is "tool <tool>", run <tool> directly from the build's
+// tool directory, pkg/tool/<goos>_<goarch>, like "go tool" does. For
+// example, "gover with 1.5.1 tool compile -V". gover also sets
+// GOTOOLDIR to the build's tool directory.
+//
+// With -quiet-on-success, hold on to the output of <command> and print
+// it, to standard error, only if <command> fails, for CI logs that
+// should only show what went wrong. Output beyond 1MB is held in a
+// temporary file rather than in memory. This also applies to "run"
+// and "gover <name> <args>".
+//
+// With -print-env, print the environment <command> would run with,
+// one variable per line, and then the command line with the path of
+// the program it would run, instead of running it. This also applies
+// to "run" and "gover <name> <args>".
+//
+// By default, the build's bin directory goes first in PATH, replacing
+// any other Go tree there, and GOTOOLDIR is set, so that everything
+// <command> runs, including other Go commands, uses the build. With
+// -no-path, only GOROOT is set: PATH and GOTOOLDIR are left as they
+// are, except that an inherited GOTOOLDIR is removed, so the go
+// command finds its tools through GOROOT alone. <command> is then
+// looked up in the current PATH; "run" still runs the build's own
+// binary. This is the stricter behavior of older versions of gover,
+// for testing that go works with GOROOT alone.
+//
+//     gover [flags] run <name> <command> [args]...
+//
+// Like "with", but <command> must be one of the binaries saved in the
+// build's bin directory, such as go or gofmt, or "tool <tool>". For
+// anything else, run fails with the list of commands it can run,
+// rather than running whatever happens to be in PATH. Use "with" or
+// "env" to run other programs with the build.
+//
+// run, like "with" and "gover <name> <args>", warns if the Go tree the
+// build was saved from no longer exists, since programs that use the
+// GOROOT built into them, rather than $GOROOT, may then misbehave.
+// doctor reports all such builds.
 //
 //     gover [flags] env <name>
 //
-// Print the environment for running commands in build <name>. This is
-// printed as shell code appropriate for eval.
+// Print the environment for running commands in build <name>: PATH,
+// GOROOT, and GOTOOLDIR, and GOFLAGS if the build was saved with
+// -goflags. This is printed as shell code appropriate for eval, or
+// with -json, as a JSON object mapping each variable to its value, for
+// editors and other tools.
+//
+//     gover [flags] label <name> [key=value]...
+//
+// Set labels of saved build <name>, such as issue=12345 or ok=true,
+// for organizing builds beyond their names. key= removes label key.
+// Given no labels, label prints the build's labels, one per line.
+// Labels are shown by info and can be used to filter list.
+//
+//     gover [flags] info <name>
+//
+// Print everything gover knows about saved build <name>: its commit,
+// names, when it was saved, the GOOS_GOARCH pairs it has packages and
+// tools for, and the build environment variables that were set when
+// it was saved.
+//
+// With -upstream ref, info also asks git in the Go tree how the
+// build's commit relates to ref, such as origin/master: whether ref
+// contains it and how many commits apart they are, the nearest tag as
+// "git describe --tags" reports it, and the branches that contain it.
+// If the Go tree doesn't have the commit, info says so instead.
+//
+//     gover [flags] match <rev>
+//
+// List the saved builds of git revision <rev> in the Go tree, one per
+// line with their names. There may be several, saved with different
+// uncommitted changes. match exits with status 3 if there are none.
+//
+//     gover [flags] du
+//
+// Print how much space each saved build uses and how much of that no
+// other build shares, followed by the total space used by the gover
+// directory. Since gover deduplicates files, builds share most of
+// their space, so the totals also say how much space only named
+// builds and only unnamed builds use, which removing them and running
+// gc would free. With -total, print just the totals. With -older-than
+// age or -newer-than age, such as 60d, 2w, or 36h, report only builds
+// saved longer or less than age ago, along with how much space only
+// they use, which helps decide what to remove.
 //
 //     gover [flags] list
 //
-// List saved builds.
+// List saved builds from oldest to newest, or newest to oldest with
+// -reverse. With -limit n, list only the first n builds. Times are
+// printed in local time, or UTC with -utc, using the layout given by
+// -time-format. With -since-commit rev, list only builds of rev or
+// commits no older than it. With -sort saved, list sorts and shows
+// builds by the time they were saved instead of their commit's author
+// date. With -sort committer, it uses the commit's committer date,
+// which is when a rebased or cherry-picked commit was made in the
+// tree rather than when it was first written. With -dirty-only, list
+// only builds saved with uncommitted changes, which have a diff hash
+// in their name, and with -clean-only, only the others. On a
+// terminal, list aligns and colors its output. Pass -no-color or set
+// $NO_COLOR to disable color.
+//
+// With -format, list prints each build using a Go template, followed
+// by a newline. The template is executed with a value that has the
+// following fields and methods:
+//
+//     .Base     name of the build's directory
+//     .Hash     full commit hash; empty if saved with -hash
+//     .Short    short name of the build, as printed by list
+//     .Date     commit author date, as a time.Time
+//     .Commit   commit committer date, as a time.Time
+//     .Saved    time the build was saved, as a time.Time
+//     .TopLine  first line of the commit message
+//     .Names    names of the build, as a []string
+//     .Dirty    whether the tree had uncommitted changes
+//     .Size     total size of the build's files in bytes
+//     .Label    value of a label, as in {{.Label "issue"}}
+//
+// For example, -format '{{.Base}} {{.Date}} {{.Names}}'.
+//
+// With -label key=value, which may be repeated, list lists only builds
+// with that label, as set by "gover label"; -label key lists builds
+// with label key set to anything.
+//
+// With -names-only, list prints just the base and names of each build
+// in that order, one per line, for scripts and shell completion. Each
+// line is something other commands accept as <name>.
+//
+// With -flat (or -keep-symlinks), list instead prints every build and
+// name in the gover directory on its own line, in directory order,
+// without folding names into the builds they refer to. Names are
+// printed as "name -> target" with the target of their symlink, and
+// names that don't refer to a saved build are marked, which is useful
+// for checking the directory's structure.
+//
+//     gover [flags] diff <name1> [name2]
+//
+// Print the differences between the source trees of two saved builds.
+// This uses "git diff --no-index" if git is available. With -summary
+// or without git, it lists the added (A), deleted (D), and modified (M)
+// files instead. Given just one name, print the uncommitted changes
+// saved with that build, with staged and unstaged changes shown
+// separately if they were saved with -split-diff.
+//
+//     gover [flags] diff-live <name>
+//
+// Report whether saved build <name> was saved from the Go tree as it is
+// now, and if not, how they differ. If both are in git, this compares
+// the build's commit and diff hash with the tree's, and prints how far
+// apart the commits are; with -summary, or for builds saved without
+// git, it also lists the source files that differ. diff-live exits
+// with status 1 if the build and the tree differ.
+//
+//     gover [flags] show <name> <path>
+//
+// Print the file at <path>, relative to the root of the Go tree, from
+// saved build <name>, for example "gover show old
+// src/runtime/proc.go". A compressed source tree is read without
+// unpacking it. show exits with status 3 if the build has no such
+// file.
+//
+//     gover [flags] copy <name> <new name>
+//
+// Copy saved build <name> to a new saved build called <new name>, for
+// example to experiment on a copy without rebuilding.
+//
+//     gover [flags] export <name> [file]
+//
+// Write saved build <name> to file, or to standard output, as a tar
+// archive. Every file in the archive is under a directory named after
+// the build's hash.
+//
+// The archive is compressed with gzip, or with the codec given by
+// -compress: gzip, zstd, or none. zstd is faster and compresses better,
+// but requires the zstd command, and so may not be available wherever
+// the archive is imported. -compress-level trades time for space, from
+// 1, the fastest, up to 9 for gzip or 19 for zstd; by default each
+// uses its own balanced level, 6 for gzip and 3 for zstd. It also
+// applies to save -compress-src.
+//
+// Symlinks in the build are archived as symlinks, except that a source
+// tree shared with -share-src is archived in full. With -dereference,
+// export archives the files and directories symlinks refer to instead,
+// so the archive doesn't depend on anything outside the build.
+//
+// With -docker, export instead writes an archive for "docker import"
+// that contains just the Go tree, at /usr/local/go or the path given
+// by -docker-root, with a source tree saved with -compress-src
+// unpacked. Since the go command in a saved build doesn't know
+// where it is, set GOROOT in the image. For example,
+//
+//     gover export -docker 1.5.1 | docker import \
+//         -c 'ENV GOROOT=/usr/local/go' \
+//         -c 'ENV PATH=/usr/local/go/bin:/usr/bin:/bin' - go:1.5.1
+//
+//     gover [flags] import [file]
+//
+// Save the build in a tar archive written by export, read from file or
+// from standard input. import recognizes how the archive was
+// compressed, so it doesn't need -compress. It refuses archives that
+// would unpack files through a symlink in the archive.
+//
+//     gover [flags] push <name> <remote>
+//     gover [flags] pull <name> <remote>
+//
+// Copy saved build <name> to or from another gover directory, <remote>,
+// along with its name if <name> is one. Builds the destination already
+// has aren't copied again. <remote> may be a remote defined in the
+// configuration file, a directory, or an ssh location of the form
+// [user@]host:dir. ssh remotes only need sh and tar, not gover.
+//
+//     gover [flags] sync <remote>
+//
+// Pull saved builds from <remote> that aren't saved locally, and push
+// local builds that <remote> doesn't have. Builds are identified by
+// their hash, so builds both sides have aren't copied. With -prune,
+// remove local builds that <remote> doesn't have instead of pushing
+// them, except for named builds, which are kept as prune keeps them.
+// With -dry-run, print what sync would do, including the space
+// -prune would reclaim, without doing it.
+//
+//     gover [flags] doctor
+//
+// Check that the environment is set up for gover and suggest fixes
+// for any problems.
+//
+// doctor and verify print each problem they find as they go. With
+// -json, they print just the problems instead, as a JSON array of
+// objects with the Severity of the problem ("note", "warning", or
+// "error"), the Build it's about, if any, the doctor Check that found
+// it, a Message, and a Hint on how to fix it, for CI and other tools to
+// check. Either way, they exit with status 1 (doctor) or 4 (verify) if
+// they find any errors, 5 if they find only warnings, and 0 otherwise.
+// For example:
+//
+//     [
+//     	{
+//     		"Severity": "warning",
+//     		"Build": "go1.20",
+//     		"Check": "saved GOROOTs",
+//     		"Message": "saved from /tmp/go, which no longer exists",
+//     		"Hint": "..."
+//     	}
+//     ]
+//
+//     gover [flags] install-hook
+//     gover [flags] uninstall-hook
+//
+// Install a git post-checkout hook in the Go tree that runs "gover
+// build" whenever a commit is checked out, so each commit visited, for
+// example while bisecting, is saved without remembering to. Since the
+// hook builds the tree, checking out a commit that isn't saved yet
+// takes as long as make.bash. uninstall-hook removes the hook. Neither
+// touches a post-checkout hook gover didn't install.
+//
+//     gover [flags] verify [name]...
+//
+// Check saved builds against the checksum manifest recorded by
+// "save -manifest". With no arguments, verify all saved builds.
+// With -parallel-hash, verify and save -manifest hash up to -parallel
+// files at once, which is much faster for large builds on machines
+// with several CPUs. The manifest is the same either way.
+//
+//     gover [flags] prune
+//
+// With -keep-file file, remove every saved build that isn't listed in
+// file, which has one hash or name per line, so that CI or other
+// orchestration that tracks which toolchains are in use can drive
+// cleanup. Blank lines and lines starting with # are ignored, and so
+// are entries that aren't saved builds, which -v notes. If file is
+// "-", the list is read from stdin. Without -keep-file, prune removes
+// the builds -prune-keep n would after a save. Either way, named
+// builds are always kept, builds in use by a running command are
+// reported as errors, and with -dry-run, prune only prints what it
+// would remove. Run gc afterward to free the space.
 //
 //     gover [flags] gc
 //
-// Clean the deduplication cache. This is useful after removing saved
-// builds to free up space.
+// Clean the deduplication cache and remove source trees shared with
+// -share-src that no saved build uses anymore. This is useful after
+// removing saved builds to free up space. gc, like sync -prune,
+// removes up to -parallel directories at once, which defaults to the
+// number of CPUs. With -dry-run, gc prints the shared source trees and
+// how many files it would remove and the space that would free,
+// without removing anything.
+//
+//     gover [flags] clean-cache
+//
+// Remove the source trees unpacked from builds saved with
+// -compress-src. They're unpacked again the next time they're needed.
+//
+//     gover -good <name> -bad <name> [flags] autobisect [--] <command>...
+//
+// Find the first saved build with which <command> fails, like "git
+// bisect run" but over saved builds rather than commits. autobisect
+// considers the builds between -good and -bad, in the order list
+// sorts them (see -sort), and runs <command> with builds in between,
+// as with "gover with", halving the range each time by whether
+// <command> succeeds. -bad may be older than -good, to look for the
+// build that fixed something. If <command> exits with status 125, the
+// build is skipped; if it exits with a status of 128 or more,
+// autobisect stops. autobisect prints the first bad build and its
+// commit message, or if builds were skipped, the builds it could be.
+//
+//     gover [flags] migrate <old dir> <new dir>
+//
+// Move the saved builds and names in gover directory <old dir> to
+// <new dir>, for example after changing $GOVER_DIR. Each build is
+// copied, checked against the original, and only then removed from
+// <old dir>, so an interrupted migrate loses nothing. Names that link
+// to builds in <old dir> are recreated to link to them in <new dir>,
+// relative or, with -absolute-links, absolute. With -copy, migrate
+// leaves <old dir> as it was.
+//
+// With -q (or -quiet), gover prints only errors and the output of
+// commands that print information, like list and env. It doesn't
+// print progress, the output of make.bash, or messages saying what it
+// did, and it overrides -v.
+//
+// With -log-json, gover prints the messages saying what it did, and
+// the commands -v prints, as JSON objects, one per line, on standard
+// error, for tools that parse what gover does. Each object has a Time
+// and either a Message or a Command and its Args, along with how long
+// the command took in seconds (Duration) and how many bytes it copied
+// (Bytes). For example:
+//
+//     {"Time":"...","Command":"cp","Args":["go/bin/go","_dedup/ab/..."],"Duration":0.002,"Bytes":12345678}
+//     {"Time":"...","Message":"saved build as `latest'"}
+//
+// Errors and warnings are printed as usual.
+//
+//
+// Exit status
+//
+// gover exits with status 0 on success and otherwise with one of the
+// following statuses, so scripts can tell failures apart:
+//
+//     1    any other error
+//     2    bad usage, such as an unknown flag
+//     3    no saved build has the given name
+//     4    a saved build is corrupt, such as failing verify
+//     5    doctor or verify found problems, but only warnings
+//     124  the command run by "with" timed out (see -timeout)
+//
+// "gover <name> <args>" and "gover with" exit with the status of the
+// command they run if it fails.
+//
+//
+// Saved builds
+//
+// Saved builds are stored in $GOVER_DIR if set. Otherwise, they are
+// stored in $XDG_CACHE_HOME/gover or the platform's user cache
+// directory: ~/.cache/gover on most systems, ~/Library/Caches/gover
+// on macOS, and %LOCALAPPDATA%\gover on Windows.
+// If that directory has no saved builds, but ~/.cache/gover, where
+// older versions of gover saved them, does, commands that only read
+// saved builds read them from there, with a note suggesting moving
+// them with migrate. Commands that save or remove builds always use
+// the configured directory, as does everything when -dir is given.
+//
+// Each build is saved in a directory named after its hash, and each
+// name is a symlink to that directory. By default, these symlinks are
+// relative, so they keep working if the whole directory is moved.
+// With -absolute-links, save creates absolute symlinks instead, which
+// keep working for tools that copy or resolve a name from another
+// directory. gover itself resolves names correctly either way.
+//
+//
+// Configuration
+//
+// gover reads its configuration from $GOVER_CONFIG if set, or
+// otherwise gover/config.json in the user's configuration directory,
+// such as ~/.config/gover/config.json. The configuration is a JSON
+// object with the following fields:
+//
+//     Remotes    map from remote names to locations for push and pull
+//     Hook       default for -hook
+//     PruneKeep  default for -prune-keep
+//     PkgTrees   default for -pkg-tree, as a list
+//
+// For example,
+//
+//     {"Remotes": {"server": "build.example.com:.cache/gover"}}
 package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -67,9 +655,10 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
-	"sort"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
 // TODO: Consider also accepting a path for name, which could let this
@@ -85,28 +674,170 @@ var (
 	verbose    = flag.Bool("v", false, "print commands being run")
 	verDir     = flag.String("dir", defaultVerDir(), "`directory` of saved Go roots")
 	noDedup    = flag.Bool("no-dedup", false, "disable deduplication of saved trees")
-	gorootFlag = flag.String("C", defaultGoroot(), "use `dir` as the root of the Go tree for save and build")
+	dedupeBin  = flag.Bool("dedupe-bin", false, "for save and build with -no-dedup, still deduplicate the binaries in bin")
+	manifest   = flag.Bool("manifest", false, "record a checksum manifest of saved trees for verify")
+	gorootFlag = flag.String("C", defaultGoroot(), "use `dir` as the root of the Go tree for save and build, instead of the one containing the current directory")
+	hashFlag   = flag.String("hash", "", "for save and build, save under `name` instead of the commit hash, without using git")
+	gitPath    = flag.String("git", "", "run git from `path` (default $GOVER_GIT or git from $PATH)")
+	gitRetries = flag.Int("git-retries", 3, "retry git commands that fail because of lock contention up to `n` times")
+	workdir    = flag.String("workdir", "", "for with and <name> <args>, run the command in `dir`")
+	outFile    = flag.String("out", "", "for with and <name> <args>, write the command's output to `file`; %n in file is replaced with <name>")
+	tee        = flag.Bool("tee", false, "for with and <name> <args>, also print the output written to -out")
+	restoreEnv = flag.Bool("restore-env", false, "for with and <name> <args>, run the command with the build environment variables recorded when the build was saved")
+	jsonOut    = flag.Bool("json", false, "for env, print the environment as a JSON object; for doctor and verify, print the problems found as a JSON array")
+	cmdTimeout = flag.Duration("timeout", 0, "for with and <name> <args>, kill the command after `duration` and exit with status 124")
+	gitTimeout = flag.Duration("git-timeout", 30*time.Second, "fail git commands that take longer than `duration` (0 means no limit)")
+
+	splitDiff        = flag.Bool("split-diff", false, "for save and build, also record staged and unstaged changes separately")
+	absoluteLinks    = flag.Bool("absolute-links", false, "for save and build, link names to builds by absolute path")
+	includeUntracked = flag.Bool("include-untracked", false, "for save and build, include untracked files in the saved diff and build hash")
+	force            = flag.Bool("force", false, "for save and build, save even if there may not be enough disk space; for apply, overwrite uncommitted changes")
+	dirtyOK          = flag.Bool("dirty", false, "for save and build, don't warn about saving a tree with uncommitted changes")
+	noDirty          = flag.Bool("no-dirty", false, "for save and build, refuse to save a tree with uncommitted changes")
+	// shareSrc is in sharesrc.go and compressSrc in extract.go.
+	noSrc     = flag.Bool("no-src", false, "for save and build, don't save the source tree")
+	noCommit  = flag.Bool("no-commit", false, "for save and build, don't save the git commit object, so list shows the save time and no message")
+	withMisc  = flag.Bool("with-misc", false, "for save and build, also save the misc tree, which some tests need (same as -include misc)")
+	includes  = stringListFlag("include", "for save and build, also save `path`, relative to the root of the Go tree (may be repeated)")
+	pkgTrees  = stringListFlag("pkg-tree", "for save and build, save `dir` under pkg, with GOOS_GOARCH replaced by the target, instead of the default trees (may be repeated)")
+	overwrite = flag.Bool("overwrite-base", false, "for save and build, replace the saved build if it already exists")
+	against   = flag.String("against", "", "for save and build, hard link files that are unchanged since saved build `name` instead of copying them")
+	hook      = flag.String("hook", "", "for save and build, run shell `command` after saving (default Hook from the configuration file)")
 )
 
+func init() {
+	flag.StringVar(gorootFlag, "goroot", *gorootFlag, "same as -C")
+}
+
+// stringList is a flag.Value that collects every value of a flag that
+// may be repeated.
+type stringList []string
+
+func stringListFlag(name, usage string) *stringList {
+	l := new(stringList)
+	flag.Var(l, name, usage)
+	return l
+}
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
 var binTools = []string{"go", "godoc", "gofmt"}
 
 func defaultVerDir() string {
-	cache := os.Getenv("XDG_CACHE_HOME")
-	if cache == "" {
-		home := os.Getenv("HOME")
-		if home == "" {
-			u, err := user.Current()
-			if err != nil {
-				home = u.HomeDir
-			}
+	return verDirFor(runtime.GOOS, os.Getenv, homeDir())
+}
+
+// verDirFor returns the default saved build directory on goos, given
+// the environment getenv and the user's home directory home.
+// $GOVER_DIR and $XDG_CACHE_HOME override the platform's usual cache
+// location.
+func verDirFor(goos string, getenv func(string) string, home string) string {
+	if dir := getenv("GOVER_DIR"); dir != "" {
+		return dir
+	}
+	if cache := getenv("XDG_CACHE_HOME"); cache != "" {
+		return filepath.Join(cache, "gover")
+	}
+	switch goos {
+	case "darwin":
+		return filepath.Join(home, "Library", "Caches", "gover")
+	case "windows":
+		if local := getenv("LOCALAPPDATA"); local != "" {
+			return filepath.Join(local, "gover")
 		}
-		cache = filepath.Join(home, ".cache")
+		return filepath.Join(home, "AppData", "Local", "gover")
 	}
-	return filepath.Join(cache, "gover")
+	return filepath.Join(home, ".cache", "gover")
+}
+
+// legacyVerDir is where gover saved builds before it honored
+// $XDG_CACHE_HOME and the platform's cache directory.
+func legacyVerDir() string {
+	return filepath.Join(homeDir(), ".cache", "gover")
+}
+
+// writeCommands are the commands that add to or remove from the gover
+// directory, and so never fall back to legacyVerDir.
+var writeCommands = map[string]bool{
+	"save": true, "build": true, "rebuild": true, "copy": true,
+	"import": true, "pull": true, "sync": true, "gc": true, "prune": true,
+	"install-hook": true, "uninstall-hook": true, "clean-cache": true,
+	"doctor": true, "migrate": true, "label": true, "clone": true,
+}
+
+// fallBackVerDir switches -dir to legacyVerDir for commands that only
+// read saved builds, if -dir wasn't given, the configured directory
+// has no saved builds, and the legacy directory has some. This keeps
+// builds from seeming to disappear after $XDG_CACHE_HOME or
+// $GOVER_DIR changes. Writes still go to the configured directory.
+func fallBackVerDir(cmd string) {
+	explicit := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "dir" {
+			explicit = true
+		}
+	})
+	legacy := legacyVerDir()
+	if explicit || writeCommands[cmd] || sameDir(*verDir, legacy) || hasBuilds(*verDir) || !hasBuilds(legacy) {
+		return
+	}
+	infof("note: no saved builds in %s; reading them from %s\n", *verDir, legacy)
+	infof("note: run \"gover migrate %s %s\" to keep using them with new saves\n", legacy, *verDir)
+	*verDir = legacy
+}
+
+// hasBuilds reports whether dir contains any saved builds.
+func hasBuilds(dir string) bool {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, file := range files {
+		if file.IsDir() && !isReservedName(file.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameDir reports whether paths a and b name the same directory.
+func sameDir(a, b string) bool {
+	stA, errA := os.Stat(a)
+	stB, errB := os.Stat(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return os.SameFile(stA, stB)
+}
+
+func homeDir() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		u, err := user.Current()
+		if err == nil {
+			home = u.HomeDir
+		}
+	}
+	return home
+}
+
+func defaultGit() string {
+	if git := os.Getenv("GOVER_GIT"); git != "" {
+		return git
+	}
+	return "git"
 }
 
 func defaultGoroot() string {
-	c := exec.Command("git", "rev-parse", "--show-cdup")
+	// This runs before flags are parsed, so it can't use -git.
+	c := exec.Command(defaultGit(), "rev-parse", "--show-cdup")
 	output, err := c.Output()
 	if err != nil {
 		return ""
@@ -138,12 +869,40 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  %s [flags] save [name] - save Go build tree\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s [flags] build [name] - build and save current tree\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s [flags] <name> <args>... - run go <args> using build <name>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] latest|oldest - print the newest or oldest saved build\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s [flags] with <name> <command>... - run <command> using build <name>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] run <name> <command>... - run one of the saved tools of build <name>\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s [flags] env <name> - print the environment for build <name> as shell code\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] apply <name> - replace the current tree's build and source with saved build <name>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] mount <name> <dir> - put a copy of saved build <name> at <dir>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] umount <dir> - remove a tree made by mount\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] rebuild <name> - rebuild saved build <name> from its commit and diff\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] clone <rev> <name> - build and save git revision <rev> as <name> without touching the current checkout\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] label <name> [key=value]... - set or print the labels of saved build <name>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] info <name> - describe saved build <name>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] match <rev> - list saved builds of git revision <rev>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] du - print the space used by saved builds\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s [flags] list - list saved builds\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s [flags] gc - clean the deduplication cache", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] diff <name1> [name2] - diff the sources of two saved builds\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] diff-live <name> - report how saved build <name> differs from the current tree\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] show <name> <path> - print file <path> from saved build <name>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] copy <name> <new name> - copy saved build <name>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] export <name> [file] - write saved build <name> as a tar archive\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] import [file] - save a build from a tar archive written by export\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] push <name> <remote> - copy saved build <name> to <remote>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] pull <name> <remote> - copy saved build <name> from <remote>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] sync <remote> - copy saved builds missing locally or from <remote>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] verify [name]... - check saved builds against their manifests\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] doctor - diagnose problems with the environment\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] install-hook - build and save each commit checked out in the Go tree\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] uninstall-hook - remove the hook added by install-hook\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] prune - remove unnamed saved builds not listed in -keep-file\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] gc - clean the deduplication cache\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] autobisect [--] <command>... - find the first saved build between -good and -bad that <command> fails with\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] migrate <old dir> <new dir> - move saved builds to another gover directory\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] clean-cache - remove extracted source trees", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\n\n")
-		fmt.Fprintf(os.Stderr, "<name> may be an unambiguous commit hash or a string name.\n\n")
+		fmt.Fprintf(os.Stderr, "<name> may be an unambiguous commit hash, a string name, \"latest\", or \"oldest\".\n\n")
 		fmt.Fprintf(os.Stderr, "Flags:\n")
 		flag.PrintDefaults()
 	}
@@ -153,13 +912,43 @@ func main() {
 		flag.Usage()
 		os.Exit(2)
 	}
+	if *quiet {
+		*verbose = false
+	}
+
+	if *gitPath == "" {
+		*gitPath = defaultGit()
+	}
+	if *gitPath != "git" {
+		if _, err := exec.LookPath(*gitPath); err != nil {
+			log.Fatalf("bad git path: %s", err)
+		}
+	}
 
 	// Make gorootFlag absolute.
 	if *gorootFlag != "" {
 		abs, err := filepath.Abs(*gorootFlag)
 		if err != nil {
-			*gorootFlag = abs
+			log.Fatal(err)
+		}
+		*gorootFlag = abs
+		flag.Visit(func(f *flag.Flag) {
+			if (f.Name == "C" || f.Name == "goroot") && !isGoroot(abs) {
+				log.Fatalf("-%s %s is not a Go tree: it has no src/cmd/go", f.Name, f.Value)
+			}
+		})
+	}
+
+	fallBackVerDir(flag.Arg(0))
+
+	if _, ok := pseudoNames[flag.Arg(0)]; ok && flag.NArg() == 1 {
+		// Print the build a pseudo-name refers to.
+		savePath, ok := resolveName(flag.Arg(0))
+		if !ok {
+			unknownName(flag.Arg(0))
 		}
+		fmt.Println(filepath.Base(savePath))
+		return
 	}
 
 	switch flag.Arg(0) {
@@ -173,7 +962,39 @@ func main() {
 			flag.Usage()
 			os.Exit(2)
 		}
-		hash, diff := getHash()
+		if *dryRun && (flag.Arg(0) == "build" || *ref != "") {
+			log.Fatal("-dry-run can't be used with build or -ref")
+		}
+		if *refDiff != "" && *ref == "" {
+			log.Fatal("-diff requires -ref")
+		}
+		checkGoflags(*goflags)
+		if *ref != "" {
+			if *hashFlag != "" {
+				log.Fatal("-ref and -hash are mutually exclusive")
+			}
+			doSaveRef(flag.Arg(1))
+			return
+		}
+
+		var hash string
+		var diff *workDiff
+		if *hashFlag != "" {
+			if *hashFlag != filepath.Base(*hashFlag) || isReservedName(*hashFlag) {
+				log.Fatalf("bad -hash name `%s'", *hashFlag)
+			}
+			hash = *hashFlag
+		} else {
+			hash, diff = getHash()
+			if diff != nil {
+				if *noDirty {
+					log.Fatalf("tree has uncommitted changes; commit or stash them, or don't pass -no-dirty")
+				}
+				if !*dirtyOK {
+					fmt.Fprintf(os.Stderr, "warning: saving dirty tree as %s; pass -dirty to silence\n", hash)
+				}
+			}
+		}
 		name := ""
 		if flag.NArg() >= 2 {
 			name = flag.Arg(1)
@@ -181,6 +1002,15 @@ func main() {
 				name = ""
 			}
 		}
+		// A name from the branch always names the branch's
+		// latest build, so it may move from another build.
+		branchName := false
+		if name == "" && *nameTemplate != "" {
+			name = templateName(hash, diff != nil)
+		} else if name == "" && *nameFromBranch {
+			name = currentBranch()
+			branchName = name != ""
+		}
 
 		// Validate paths.
 		savePath, hashExists := resolveName(hash)
@@ -196,37 +1026,124 @@ func main() {
 		}
 
 		if flag.Arg(0) == "build" {
-			if hashExists {
-				if !nameRight {
+			if hashExists && !*overwrite {
+				if !nameRight && !branchName {
 					log.Fatalf("name `%s' exists and refers to another build", name)
 				}
 				msg := fmt.Sprintf("saved build `%s' already exists", hash)
 				if namePath != "" && !nameExists {
 					doLink(hash, namePath)
 					msg += fmt.Sprintf("; added name `%s'", name)
+				} else if !nameRight {
+					moveName(hash, namePath)
+					msg += fmt.Sprintf("; moved name `%s' to it", name)
 				}
-				fmt.Fprintln(os.Stderr, msg)
+				if *tagLatest {
+					doTagLatest(hash)
+				}
+				infof("%s\n", msg)
 				os.Exit(0)
 			}
+			if nameExists && !nameRight && !branchName {
+				log.Fatalf("name `%s' exists and refers to another build", name)
+			}
 
 			doBuild()
 		} else {
-			if hashExists {
+			if hashExists && !*overwrite {
 				log.Fatalf("saved build `%s' already exists", hash)
 			}
-			if nameExists {
+			if nameExists && !nameRight && !branchName {
 				log.Fatalf("saved build `%s' already exists", name)
 			}
 		}
-		doSave(hash, diff)
-		if namePath != "" {
+		if *withMisc {
+			*includes = append(*includes, "misc")
+		}
+		if *compressSrc && (*noSrc || *shareSrc) {
+			log.Fatal("-compress-src can't be used with -no-src or -share-src")
+		}
+		checkIncludes()
+		if *dryRun {
+			doSaveDryRun(goroot())
+			pruneAfterSave()
+			return
+		}
+		if !*force {
+			checkSpace()
+		}
+		// Keep commands from running the build, and other saves
+		// and updates from writing it, until it's complete and
+		// named.
+		unlock := lockSave(hash)
+		if hashExists {
+			overwriteBase(savePath, diff)
+		} else {
+			doSave(savePath, diff)
+		}
+		if nameExists && !nameRight && branchName {
+			moveName(hash, namePath)
+		} else if namePath != "" && !nameExists {
 			doLink(hash, namePath)
 		}
+		if *tagLatest {
+			doTagLatest(hash)
+		}
+		// The hook may run the build.
+		unlock()
 		if name == "" {
-			fmt.Fprintf(os.Stderr, "saved build as `%s'\n", hash)
+			infof("saved build as `%s'\n", hash)
 		} else {
-			fmt.Fprintf(os.Stderr, "saved build as `%s' and `%s'\n", hash, name)
+			infof("saved build as `%s' and `%s'\n", hash, name)
+		}
+		curStats.print()
+		runHook(savePath, name)
+		pruneAfterSave()
+
+	case "label":
+		if flag.NArg() < 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doLabel(flag.Arg(1), flag.Args()[2:])
+
+	case "info":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doInfo(flag.Arg(1))
+
+	case "match":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doMatch(flag.Arg(1))
+
+	case "du":
+		if flag.NArg() != 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doDu()
+
+	case "rebuild":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doRebuild(flag.Arg(1))
+
+	case "clone":
+		if flag.NArg() != 3 {
+			flag.Usage()
+			os.Exit(2)
 		}
+		if *ref != "" || *refDiff != "" {
+			log.Fatal("clone can't be used with -ref or -diff")
+		}
+		doClone(flag.Arg(1), flag.Arg(2))
 
 	case "list":
 		if flag.NArg() > 1 {
@@ -235,6 +1152,81 @@ func main() {
 		}
 		doList()
 
+	case "diff":
+		switch flag.NArg() {
+		case 2:
+			doShowDiff(flag.Arg(1))
+		case 3:
+			doDiff(flag.Arg(1), flag.Arg(2))
+		default:
+			flag.Usage()
+			os.Exit(2)
+		}
+
+	case "diff-live":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doDiffLive(flag.Arg(1))
+
+	case "copy":
+		if flag.NArg() != 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doCopy(flag.Arg(1), flag.Arg(2))
+
+	case "export":
+		switch flag.NArg() {
+		case 2:
+			doExport(flag.Arg(1), "-")
+		case 3:
+			doExport(flag.Arg(1), flag.Arg(2))
+		default:
+			flag.Usage()
+			os.Exit(2)
+		}
+
+	case "import":
+		switch flag.NArg() {
+		case 1:
+			doImport("-")
+		case 2:
+			doImport(flag.Arg(1))
+		default:
+			flag.Usage()
+			os.Exit(2)
+		}
+
+	case "push", "pull":
+		if flag.NArg() != 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if flag.Arg(0) == "push" {
+			doPush(flag.Arg(1), flag.Arg(2))
+		} else {
+			doPull(flag.Arg(1), flag.Arg(2))
+		}
+
+	case "sync":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doSync(flag.Arg(1))
+
+	case "verify":
+		doVerify(flag.Args()[1:])
+
+	case "doctor":
+		if flag.NArg() > 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doDoctor()
+
 	case "with":
 		if flag.NArg() < 3 {
 			flag.Usage()
@@ -256,62 +1248,268 @@ func main() {
 		}
 		doGC()
 
-	default:
-		if flag.NArg() < 2 {
+	case "install-hook", "uninstall-hook":
+		if flag.NArg() != 1 {
 			flag.Usage()
 			os.Exit(2)
 		}
-		if _, ok := resolveName(flag.Arg(0)); !ok {
-			log.Fatalf("unknown name or subcommand `%s'", flag.Arg(0))
+		if flag.Arg(0) == "install-hook" {
+			doInstallHook()
+		} else {
+			doUninstallHook()
+		}
+
+	case "run":
+		if flag.NArg() < 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doRun(flag.Arg(1), flag.Args()[2:])
+
+	case "show":
+		if flag.NArg() != 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doShow(flag.Arg(1), flag.Arg(2))
+
+	case "apply":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doApply(flag.Arg(1))
+
+	case "autobisect":
+		args := flag.Args()[1:]
+		if len(args) > 0 && args[0] == "--" {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doAutobisect(args)
+
+	case "prune":
+		if flag.NArg() != 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doPrune()
+
+	case "migrate":
+		if flag.NArg() != 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doMigrate(flag.Arg(1), flag.Arg(2))
+
+	case "mount":
+		if flag.NArg() != 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doMount(flag.Arg(1), flag.Arg(2))
+
+	case "umount":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doUmount(flag.Arg(1))
+
+	case "clean-cache":
+		if flag.NArg() > 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		doCleanCache()
+
+	default:
+		if flag.NArg() < 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if _, ok := resolveName(flag.Arg(0)); !ok {
+			exitf(exitNotFound, "unknown name or subcommand `%s'", flag.Arg(0))
 		}
 		doWith(flag.Arg(0), append([]string{"go"}, flag.Args()[1:]...))
 	}
 }
 
+// gitContext returns a context that enforces -git-timeout.
+func gitContext() (context.Context, context.CancelFunc) {
+	if *gitTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), *gitTimeout)
+}
+
+// isGitRepo returns true if dir is in a git work tree. This asks git
+// rather than looking for a .git directory because in a linked
+// worktree .git is a file.
+func isGitRepo(dir string) bool {
+	args := []string{"-C", dir, "rev-parse", "--is-inside-work-tree"}
+	output, _, err := runGit(args)
+	if _, ok := err.(gitTimeoutError); ok {
+		log.Fatalf("error executing git %s: %s", strings.Join(args, " "), err)
+	}
+	return err == nil && strings.TrimSpace(string(output)) == "true"
+}
+
 func goroot() string {
 	if *gorootFlag == "" {
-		log.Fatal("not a git repository")
+		log.Fatal("not in a git checkout of the Go tree; run gover from one or pass -C dir")
 	}
 	return *gorootFlag
 }
 
+// checkGitGoroot exits with an explanation if goroot() is not a git
+// checkout.
+func checkGitGoroot() {
+	if !isGitRepo(goroot()) {
+		log.Fatalf("%s is not a git repository\n"+
+			"gover names saved builds after their git commit, so it can only save a Go tree\n"+
+			"that is a git checkout (not, for example, an unpacked binary release);\n"+
+			"pass -hash name to save it under name without using git", goroot())
+	}
+}
+
 func gitCmd(cmd string, args ...string) string {
+	return gitCmdStatus(0, cmd, args...)
+}
+
+// gitCmdStatus is like gitCmd, but also treats exit status okStatus
+// as success. This is useful for commands like "git diff --no-index"
+// that use a non-zero exit status to report differences.
+func gitCmdStatus(okStatus int, cmd string, args ...string) string {
 	args = append([]string{"-C", goroot(), cmd}, args...)
-	c := exec.Command("git", args...)
-	c.Stderr = os.Stderr
-	output, err := c.Output()
-	if err != nil {
-		log.Fatalf("error executing git %s: %s", strings.Join(args, " "), err)
+	for try := 0; ; try++ {
+		output, stderr, err := runGit(args)
+		if ee, ok := err.(*exec.ExitError); ok && okStatus != 0 && ee.ExitCode() == okStatus {
+			err = nil
+		}
+		if err != nil && try < *gitRetries && transientGitErrRe.Match(stderr) {
+			// Back off and try again.
+			time.Sleep((100 * time.Millisecond) << uint(try))
+			continue
+		}
+		os.Stderr.Write(stderr)
+		if err != nil {
+			log.Fatalf("error executing git %s: %s", strings.Join(args, " "), err)
+		}
+		return string(output)
 	}
-	return string(output)
 }
 
-func getHash() (string, []byte) {
+// transientGitErrRe matches git errors that are likely to go away if
+// retried, such as another git process holding a lock.
+var transientGitErrRe = regexp.MustCompile(`index\.lock|Unable to create '[^']*\.lock'|cannot lock ref`)
+
+// runGit runs git with args and returns its stdout and stderr.
+func runGit(args []string) (stdout, stderr []byte, err error) {
+	ctx, cancel := gitContext()
+	defer cancel()
+	var errBuf bytes.Buffer
+	c := exec.CommandContext(ctx, *gitPath, args...)
+	c.Stderr = &errBuf
+	stdout, err = c.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = gitTimeoutError{}
+	}
+	return stdout, errBuf.Bytes(), err
+}
+
+type gitTimeoutError struct{}
+
+func (gitTimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", *gitTimeout)
+}
+
+// A workDiff records the uncommitted changes in a Go tree.
+type workDiff struct {
+	all []byte // "git diff HEAD" plus untracked files
+
+	// With -split-diff, staged and unstaged record the changes in
+	// the index and the work tree separately.
+	staged, unstaged []byte
+}
+
+func getHash() (string, *workDiff) {
+	return workHash(*splitDiff, *includeUntracked)
+}
+
+// workHash returns the build hash of the Go tree in goroot() and its
+// uncommitted changes, recording staged and unstaged changes
+// separately if split is set and including untracked files if
+// untracked is set. The hash only depends on the combined diff.
+func workHash(split, untracked bool) (string, *workDiff) {
+	checkGitGoroot()
+
 	rev := strings.TrimSpace(string(gitCmd("rev-parse", "HEAD")))
 
-	diff := []byte(gitCmd("diff", "HEAD"))
+	diff := &workDiff{all: []byte(gitCmd("diff", "HEAD"))}
+	if split {
+		diff.staged = []byte(gitCmd("diff", "--cached"))
+		diff.unstaged = []byte(gitCmd("diff"))
+	}
+
+	// "git diff" ignores untracked files, but they're still part of
+	// the saved tree.
+	paths := untrackedFiles()
+	if len(paths) > 0 && !untracked {
+		fmt.Fprintf(os.Stderr, "warning: %d untracked file(s) are not reflected in the build hash; pass -include-untracked to include them\n", len(paths))
+	} else {
+		for _, path := range paths {
+			d := gitCmdStatus(1, "diff", "--no-index", "--binary", "--", os.DevNull, path)
+			diff.all = append(diff.all, d...)
+			if split {
+				diff.unstaged = append(diff.unstaged, d...)
+			}
+		}
+	}
 
-	if len(bytes.TrimSpace(diff)) > 0 {
-		diffHash := fmt.Sprintf("%x", sha1.Sum(diff))
+	if len(bytes.TrimSpace(diff.all)) > 0 {
+		diffHash := fmt.Sprintf("%x", sha1.Sum(diff.all))
 		return rev + "+" + diffHash[:10], diff
 	}
 	return rev, nil
 }
 
+// untrackedFiles returns the paths of the files in goroot() that are
+// neither tracked nor ignored by git, relative to goroot().
+func untrackedFiles() []string {
+	var paths []string
+	for _, path := range strings.Split(gitCmd("ls-files", "--others", "--exclude-standard", "-z"), "\x00") {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
 func doBuild() {
-	c := exec.Command("./make.bash")
-	c.Dir = filepath.Join(goroot(), "src")
-	c.Stdout = os.Stdout
-	c.Stderr = os.Stderr
-	if err := c.Run(); err != nil {
+	if err := buildTree(goroot(), nil); err != nil {
 		log.Fatalf("error executing make.bash: %s", err)
-		os.Exit(1)
 	}
 }
 
-func doSave(hash string, diff []byte) {
-	// Create a minimal GOROOT at $GOROOT/gover/hash.
-	savePath, _ := resolveName(hash)
+// buildTree runs make.bash in the Go tree at root, with environment
+// env if it's not nil.
+func buildTree(root string, env []string) error {
+	c := exec.Command("./make.bash")
+	c.Dir = filepath.Join(root, "src")
+	c.Env = env
+	if !*quiet {
+		c.Stdout = os.Stdout
+	}
+	c.Stderr = os.Stderr
+	return c.Run()
+}
+
+// saveOSArch returns the GOOS_GOARCH of the build to save.
+func saveOSArch() string {
 	goos, goarch := runtime.GOOS, runtime.GOARCH
 	if x := os.Getenv("GOOS"); x != "" {
 		goos = x
@@ -319,107 +1517,462 @@ func doSave(hash string, diff []byte) {
 	if x := os.Getenv("GOARCH"); x != "" {
 		goarch = x
 	}
-	osArch := goos + "_" + goarch
+	return goos + "_" + goarch
+}
+
+// defaultPkgTrees are the directories under pkg that save copies
+// unless -pkg-tree or PkgTrees in the configuration file says
+// otherwise. GOOS_GOARCH is replaced with the target being saved.
+var defaultPkgTrees = []string{"GOOS_GOARCH", "tool/GOOS_GOARCH", "include"}
+
+// savedTrees returns the directories, relative to GOROOT, that save
+// copies in addition to the binTools.
+func savedTrees(osArch string) []string {
+	// TODO: Use "go list" and save only the stuff depended on? Or
+	// maybe just save the types of files go list can return, plus
+	// "testdata" directories?
+	pkg := []string(*pkgTrees)
+	if len(pkg) == 0 {
+		pkg = loadConfig().PkgTrees
+	}
+	if len(pkg) == 0 {
+		pkg = defaultPkgTrees
+	}
+	var trees []string
+	for _, dir := range pkg {
+		dir = filepath.Clean(filepath.FromSlash(strings.Replace(dir, "GOOS_GOARCH", osArch, -1)))
+		if filepath.IsAbs(dir) || dir == "." || dir == ".." || strings.HasPrefix(dir, ".."+string(filepath.Separator)) {
+			log.Fatalf("bad pkg tree `%s'; it must be a directory under pkg", dir)
+		}
+		trees = append(trees, filepath.Join("pkg", dir))
+	}
+	return append(trees, "src")
+}
+
+// treesToSave returns the directories, relative to GOROOT, that save
+// copies given the flags: savedTrees plus any -include paths, without
+// src if -no-src is set.
+func treesToSave(osArch string) []string {
+	var trees []string
+	for _, tree := range savedTrees(osArch) {
+		if tree != "src" || !*noSrc {
+			trees = append(trees, tree)
+		}
+	}
+	return append(trees, *includes...)
+}
+
+// estimateSaveSize returns an upper bound on the number of bytes
+// saving the Go tree at goroot will copy. Deduplication may make the
+// actual cost much lower.
+func estimateSaveSize(goroot string) (int64, error) {
+	var total int64
+	for _, binTool := range binTools {
+		if st, err := os.Stat(filepath.Join(goroot, "bin", binTool)); err == nil {
+			total += st.Size()
+		}
+	}
+	for _, tree := range treesToSave(saveOSArch()) {
+		size, err := treeSize(filepath.Join(goroot, tree))
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// checkIncludes exits if any -include path is outside the Go tree or
+// doesn't exist.
+func checkIncludes() {
+	for i, path := range *includes {
+		path = filepath.Clean(path)
+		if filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+			log.Fatalf("-include path %s is not relative to the Go tree", (*includes)[i])
+		}
+		if _, err := os.Lstat(filepath.Join(goroot(), path)); err != nil {
+			log.Fatal(err)
+		}
+		(*includes)[i] = path
+	}
+}
+
+// checkSpace exits if saving the Go tree may not fit in the free space
+// on the file system containing the saved build directory, rather than
+// running out of space partway through the save.
+func checkSpace() {
+	need, err := estimateSaveSize(goroot())
+	if err != nil {
+		log.Fatal(err)
+	}
+	free, err := freeSpace(*verDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if uint64(need) > free {
+		log.Fatalf("saving may need up to %s, but only %s is free in %s\n"+
+			"free up disk space, pass -dir to use another file system, or pass -force to save anyway", fmtBytes(need), fmtBytes(int64(free)), *verDir)
+	}
+}
+
+// doSave saves a minimal GOROOT at savePath.
+func doSave(savePath string, diff *workDiff) {
+	osArch := saveOSArch()
 
 	goroot := goroot()
+	if *against != "" {
+		baseline.from, baseline.to = resolveBase(*against), savePath
+	}
+	saveBoundary = nil
+	if *oneFilesystem {
+		saveBoundary = newFSBoundary(goroot)
+	}
+	startProgress("saving")
+	startStats(goroot)
+	bin := []string{}
+	for _, binTool := range binTools {
+		curProgress.addTotal(filepath.Join(goroot, "bin", binTool))
+	}
+	for _, tree := range treesToSave(osArch) {
+		curProgress.addTotal(filepath.Join(goroot, tree))
+	}
 	for _, binTool := range binTools {
 		src := filepath.Join(goroot, "bin", binTool)
-		if _, err := os.Stat(src); err == nil {
-			cp(src, filepath.Join(savePath, "bin", binTool))
+		if st, err := os.Stat(src); err == nil && !saveBoundary.crosses(src, st) {
+			copyDedup(src, filepath.Join(savePath, "bin", binTool), !*noDedup || *dedupeBin)
+			curStats.add(src, st.Size())
+			bin = append(bin, binTool)
 		}
 	}
-	cpR(filepath.Join(goroot, "pkg", osArch), filepath.Join(savePath, "pkg", osArch))
-	cpR(filepath.Join(goroot, "pkg", "tool", osArch), filepath.Join(savePath, "pkg", "tool", osArch))
-	cpR(filepath.Join(goroot, "pkg", "include"), filepath.Join(savePath, "pkg", "include"))
-	// TODO: Use "go list" and save only the stuff depended on? Or
-	// maybe just save the types of files go list can return, plus
-	// "testdata" directories?
-	cpR(filepath.Join(goroot, "src"), filepath.Join(savePath, "src"))
+	var srcHash string
+	for _, tree := range treesToSave(osArch) {
+		if missingTree(goroot, tree) {
+			continue
+		}
+		if st, err := os.Lstat(filepath.Join(goroot, tree)); err == nil && saveBoundary.crosses(filepath.Join(goroot, tree), st) {
+			continue
+		}
+		if tree == "src" && *shareSrc {
+			srcHash = saveSharedSrc(filepath.Join(goroot, tree), savePath)
+			continue
+		}
+		if tree == "src" && *compressSrc {
+			saveCompressedSrc(filepath.Join(goroot, tree), savePath)
+			curStats.addTree(filepath.Join(goroot, tree))
+			continue
+		}
+		cpR(filepath.Join(goroot, tree), filepath.Join(savePath, tree))
+	}
+	stopProgress()
 
 	if diff != nil {
-		if err := ioutil.WriteFile(filepath.Join(savePath, "diff"), diff, 0666); err != nil {
+		if err := ioutil.WriteFile(filepath.Join(savePath, "diff"), diff.all, 0666); err != nil {
 			log.Fatal(err)
 		}
+		if *splitDiff {
+			if err := ioutil.WriteFile(filepath.Join(savePath, "diff.staged"), diff.staged, 0666); err != nil {
+				log.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(savePath, "diff.unstaged"), diff.unstaged, 0666); err != nil {
+				log.Fatal(err)
+			}
+		}
 	}
 
 	// Save commit object.
-	commit := gitCmd("cat-file", "commit", "HEAD")
-	if err := ioutil.WriteFile(filepath.Join(savePath, "commit"), []byte(commit), 0666); err != nil {
-		log.Fatal(err)
+	if *hashFlag == "" && !*noCommit {
+		commit := gitCmd("cat-file", "commit", "HEAD")
+		if err := ioutil.WriteFile(filepath.Join(savePath, "commit"), []byte(commit), 0666); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	meta := &buildMeta{SaveTime: time.Now(), Env: buildEnv(), NoSrc: *noSrc, SharedSrc: srcHash, Bin: bin, IncludeUntracked: *includeUntracked, Goflags: *goflags}
+	if abs, err := filepath.Abs(goroot); err == nil {
+		meta.Goroot = abs
+	}
+	for _, path := range *includes {
+		meta.Include = append(meta.Include, filepath.ToSlash(path))
+	}
+	writeMeta(savePath, meta)
+
+	if *manifest {
+		writeManifest(savePath)
 	}
 }
 
-func doLink(hash, namePath string) {
-	err := os.Symlink(hash, namePath)
+// lockSave takes the exclusive lock on saved build base for saving
+// it, and with -share-src the shared lock on the shared source trees,
+// and returns a function that releases them. It exits if a running
+// command or another save holds the build's lock.
+func lockSave(base string) (unlock func()) {
+	unlock, err := lockBuild(base, true)
+	if err == errBuildInUse {
+		log.Fatalf("saved build `%s' is in use by a running command; try again when it's done", base)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if !*shareSrc {
+		return unlock
+	}
+	unlockBuild := unlock
+	unlockSrc, err := lockSharedSrc(false)
 	if err != nil {
 		log.Fatal(err)
 	}
+	return func() {
+		unlockSrc()
+		unlockBuild()
+	}
 }
 
-type buildInfoSorter []*buildInfo
-
-func (s buildInfoSorter) Len() int {
-	return len(s)
-}
+// overwriteBase replaces the saved build at savePath with the Go tree
+// being saved. It saves the tree next to the old build first and then
+// swaps them, so the old build's files don't linger in the new one and
+// a failed save leaves the old build alone. The caller must hold the
+// build's lock from lockSave.
+func overwriteBase(savePath string, diff *workDiff) {
+	base := filepath.Base(savePath)
+	tmp, err := ioutil.TempDir(*verDir, "_tmp-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Chmod(tmp, 0755); err != nil {
+		log.Fatal(err)
+	}
+	// Save into tmp directly, since it's at the same depth as
+	// savePath for any relative links, like -share-src's.
+	doSave(tmp, diff)
 
-func (s buildInfoSorter) Less(i, j int) bool {
-	return s[i].commit.authorDate.Before(s[j].commit.authorDate)
+	old := tmp + "-old"
+	if err := os.Rename(savePath, old); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Rename(tmp, savePath); err != nil {
+		os.Rename(old, savePath)
+		log.Fatal(err)
+	}
+	if err := os.RemoveAll(old); err != nil {
+		log.Printf("failed to remove old build: %v", err)
+	}
+	// Its extracted source tree, if any, is out of date.
+	os.RemoveAll(filepath.Join(*verDir, extractedDir, base))
 }
 
-func (s buildInfoSorter) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
+// runHook runs the post-save hook, if any, for the build saved at
+// savePath as name. A failing hook doesn't undo the save, so it's only
+// a warning.
+func runHook(savePath, name string) {
+	cmd := *hook
+	if cmd == "" {
+		cmd = loadConfig().Hook
+	}
+	if cmd == "" {
+		return
+	}
+	if name == "" {
+		name = filepath.Base(savePath)
+	}
+	c := exec.Command("sh", "-c", cmd)
+	c.Env = append(os.Environ(), "GOVER_SAVE_PATH="+savePath, "GOVER_SAVE_NAME="+name)
+	c.Stdout, c.Stderr = os.Stdout, os.Stderr
+	done := logCommand("sh", "-c", cmd)
+	err := c.Run()
+	done(0)
+	if err != nil {
+		log.Printf("warning: hook %s failed: %s", shellEscape(cmd), err)
+	}
 }
 
-func doList() {
-	builds, err := listBuilds(listNames | listCommit)
+// doLink makes namePath a name for saved build hash, and exits if the
+// name doesn't work.
+func doLink(hash, namePath string) {
+	target := hash
+	if *absoluteLinks {
+		abs, err := filepath.Abs(filepath.Join(*verDir, hash))
+		if err != nil {
+			log.Fatal(err)
+		}
+		target = abs
+	}
+	err := os.Symlink(target, namePath)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	sort.Sort(buildInfoSorter(builds))
-
-	for _, info := range builds {
-		fmt.Print(info.shortName())
-		if !info.commit.authorDate.IsZero() {
-			fmt.Printf(" %s", info.commit.authorDate.Local().Format("2006-01-02T15:04:05"))
+	// Check that the name works now instead of leaving it to fail
+	// confusingly when it's used, which can happen on unusual file
+	// systems, like some overlays.
+	st1, err1 := os.Stat(filepath.Join(*verDir, hash))
+	st2, err2 := os.Stat(namePath)
+	if err1 != nil || err2 != nil || !os.SameFile(st1, st2) {
+		os.Remove(namePath)
+		msg := "doesn't resolve to it"
+		if err2 != nil {
+			msg = fmt.Sprintf("doesn't resolve: %v", err2)
 		}
-		if len(info.names) > 0 {
-			fmt.Printf(" %s", info.names)
+		hint := "; try -absolute-links"
+		if *absoluteLinks {
+			hint = ""
 		}
-		if info.commit.topLine != "" {
-			fmt.Printf(" %s", info.commit.topLine)
-		}
-		fmt.Println()
+		log.Fatalf("name `%s' for saved build `%s' %s%s", filepath.Base(namePath), hash, msg, hint)
 	}
 }
 
 func doWith(name string, cmd []string) {
 	savePath, ok := resolveName(name)
 	if !ok {
-		log.Fatalf("unknown name `%s'", name)
+		unknownName(name)
+	}
+	var dir string
+	if *workdir != "" {
+		var err error
+		if dir, err = filepath.Abs(*workdir); err != nil {
+			log.Fatal(err)
+		}
+		if st, err := os.Stat(dir); err != nil {
+			log.Fatal(err)
+		} else if !st.IsDir() {
+			log.Fatalf("-workdir %s is not a directory", *workdir)
+		}
+		// GOROOT and PATH must still work from dir.
+		if savePath, err = filepath.Abs(savePath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Keep the build from being replaced while the command runs.
+	if base, err := filepath.EvalSymlinks(savePath); err == nil {
+		if unlock, err := lockBuild(filepath.Base(base), false); err == nil {
+			defer unlock()
+		}
+	}
+	if root := movedGoroot(savePath); root != "" {
+		log.Printf("warning: build `%s' was saved from %s, which no longer exists; programs that use the GOROOT built into them may misbehave", name, root)
 	}
 	goroot, path := getEnv(savePath)
+	if cmd[0] == "tool" {
+		if len(cmd) < 2 {
+			log.Fatal("missing tool name")
+		}
+		tool := filepath.Join(toolDir(goroot), cmd[1])
+		if _, err := os.Stat(tool); err != nil {
+			log.Fatalf("build `%s' has no tool `%s' in %s", name, cmd[1], toolDir(goroot))
+		}
+		cmd = append([]string{tool}, cmd[2:]...)
+	}
 
 	// exec.Command looks up the command in this process' PATH.
 	// Unfortunately, this is a rather complex process and there's
 	// no way to provide a different PATH, so set the process'
 	// PATH.
-	os.Setenv("PATH", path)
-	c := exec.Command(cmd[0], cmd[1:]...)
+	if !*noPath {
+		os.Setenv("PATH", path)
+	}
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if *cmdTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *cmdTimeout)
+	}
+	defer cancel()
+	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	c.Dir = dir
+	if *cmdTimeout > 0 && !isTerminal(os.Stdin) {
+		// Run the command in its own process group so a timeout
+		// kills anything it started, too. On a terminal, that
+		// would take the command out of the foreground, so
+		// interactive commands couldn't read their input or be
+		// interrupted, and only the command itself is killed.
+		c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		c.Cancel = func() error {
+			return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+		}
+	}
 
 	// Build the rest of the command environment.
+	var savedEnv map[string]string
+	if *restoreEnv {
+		if savedEnv = readMeta(savePath).Env; savedEnv == nil {
+			log.Printf("warning: build `%s' has no recorded environment", name)
+		}
+	}
 	for _, env := range os.Environ() {
-		if strings.HasPrefix(env, "GOROOT=") {
+		if strings.HasPrefix(env, "GOROOT=") || strings.HasPrefix(env, "GOTOOLDIR=") || dir != "" && strings.HasPrefix(env, "PWD=") {
 			continue
 		}
 		c.Env = append(c.Env, env)
 	}
 	c.Env = append(c.Env, "GOROOT="+goroot)
+	if !*noPath {
+		c.Env = append(c.Env, "GOTOOLDIR="+toolDir(goroot))
+	}
+	if dir != "" {
+		c.Env = append(c.Env, "PWD="+dir)
+	}
+	if savedEnv != nil {
+		c.Env = restoreBuildEnv(c.Env, savedEnv)
+	}
+	if flags, ok := buildGoflags(savePath); ok {
+		// The build's flags win over GOFLAGS from the
+		// environment, even a recorded one.
+		env := c.Env[:0]
+		for _, kv := range c.Env {
+			if !strings.HasPrefix(kv, "GOFLAGS=") {
+				env = append(env, kv)
+			}
+		}
+		c.Env = append(env, "GOFLAGS="+flags)
+	}
+	if *printEnv {
+		printCommand(c)
+		return
+	}
 
 	// Run command.
 	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
-	if err := c.Run(); err != nil {
-		fmt.Printf("command failed: %s\n", err)
+	if *outFile != "" {
+		f, err := os.Create(strings.Replace(*outFile, "%n", name, -1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		if *tee {
+			c.Stdout, c.Stderr = io.MultiWriter(f, os.Stdout), io.MultiWriter(f, os.Stderr)
+		} else {
+			c.Stdout, c.Stderr = f, f
+		}
+	}
+	var held *spillBuffer
+	if *quietOnSuccess {
+		if *outFile != "" || *ttyFlag {
+			log.Fatal("-quiet-on-success can't be used with -out or -tty")
+		}
+		held = holdOutput(c)
+		defer held.Close()
+	}
+	run := c.Run
+	if *ttyFlag {
+		run = func() error { return runTTY(c) }
+	}
+	if err := run(); err != nil {
+		if held != nil {
+			if _, err := held.WriteTo(os.Stderr); err != nil {
+				log.Printf("error printing the command's output: %s", err)
+			}
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			fmt.Fprintf(os.Stderr, "command timed out after %s\n", *cmdTimeout)
+			// This is the same status timeout(1) uses.
+			os.Exit(exitTimeout)
+		}
+		fmt.Fprintf(os.Stderr, "command failed: %s\n", err)
+		// Exit like the command did, with the status shells
+		// use for a command killed by a signal if it was.
+		if ee, ok := err.(*exec.ExitError); ok {
+			if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+				os.Exit(128 + int(ws.Signal()))
+			}
+			os.Exit(ee.ExitCode())
+		}
 		os.Exit(1)
 	}
 }
@@ -427,17 +1980,47 @@ func doWith(name string, cmd []string) {
 func doEnv(name string) {
 	savePath, ok := resolveName(name)
 	if !ok {
-		log.Fatalf("unknown name `%s'", name)
+		unknownName(name)
 	}
 
 	goroot, path := getEnv(savePath)
+	flags, setFlags := buildGoflags(savePath)
+	if *jsonOut {
+		env := map[string]string{
+			"GOROOT":    goroot,
+			"PATH":      path,
+			"GOTOOLDIR": toolDir(goroot),
+		}
+		if setFlags {
+			env["GOFLAGS"] = flags
+		}
+		data, err := json.MarshalIndent(env, "", "\t")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s\n", data)
+		return
+	}
 	fmt.Printf("PATH=%s;\n", shellEscape(path))
 	fmt.Printf("GOROOT=%s;\n", shellEscape(goroot))
-	fmt.Printf("export GOROOT;\n")
+	fmt.Printf("GOTOOLDIR=%s;\n", shellEscape(toolDir(goroot)))
+	if setFlags {
+		fmt.Printf("GOFLAGS=%s;\n", shellEscape(flags))
+		fmt.Printf("export GOROOT GOTOOLDIR GOFLAGS;\n")
+		return
+	}
+	fmt.Printf("export GOROOT GOTOOLDIR;\n")
+}
+
+// toolDir returns the directory of the tools, like compile, for this
+// platform in the Go tree rooted at goroot.
+func toolDir(goroot string) string {
+	return filepath.Join(goroot, "pkg", "tool", runtime.GOOS+"_"+runtime.GOARCH)
 }
 
 // getEnv returns the GOROOT and PATH for the Go tree rooted at savePath.
 func getEnv(savePath string) (goroot, path string) {
+	savePath = extractedRoot(savePath)
 	p := []string{filepath.Join(savePath, "bin")}
 	// Strip existing Go tree from PATH.
 	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
@@ -453,38 +2036,123 @@ func getEnv(savePath string) (goroot, path string) {
 var goodDedupPath = regexp.MustCompile("/[0-9a-f]{2}/[0-9a-f]{38}$")
 
 func doGC() {
-	removed := 0
-	filepath.Walk(filepath.Join(*verDir, "_dedup"), func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		st, ok := info.Sys().(*syscall.Stat_t)
-		if !ok || st.Nlink != 1 {
-			return nil
+	if !*dryRun {
+		unlock, err := lockSharedSrc(true)
+		if err != nil {
+			log.Fatal(err)
 		}
-		if !goodDedupPath.MatchString(path) {
-			// Be paranoid about removing files.
-			log.Printf("unexpected file in dedup cache: %s\n", path)
+		defer unlock()
+	}
+	plan := planRemoval(nil, true)
+	if *dryRun {
+		plan.print(func(format string, args ...interface{}) {
+			fmt.Printf(format, args...)
+		})
+		fmt.Printf("would remove %d unused file(s), reclaiming %s\n", plan.dedup, fmtBytes(plan.size))
+		return
+	}
+
+	// Remove unused shared source trees first so their files
+	// become unused in the dedup cache.
+	removeSharedSrc(plan.sharedSrc)
+
+	dirs, err := filepath.Glob(filepath.Join(*verDir, "_dedup", "*"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Clean each directory of the dedup cache in parallel.
+	var mu sync.Mutex
+	removed, reclaimed := 0, int64(0)
+	errs := forEachParallel(dirs, func(dir string) error {
+		return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			st, ok := info.Sys().(*syscall.Stat_t)
+			if !ok || st.Nlink != 1 {
+				return nil
+			}
+			if !goodDedupPath.MatchString(path) {
+				// Be paranoid about removing files.
+				log.Printf("unexpected file in dedup cache: %s\n", path)
+				return nil
+			}
+			if err := os.Remove(path); err != nil {
+				log.Printf("failed to remove %s: %v", path, err)
+			} else {
+				mu.Lock()
+				removed++
+				reclaimed += info.Size()
+				mu.Unlock()
+			}
 			return nil
-		}
-		if err := os.Remove(path); err != nil {
-			log.Printf("failed to remove %s: %v", path, err)
-		} else {
-			removed++
-		}
-		return nil
+		})
 	})
-	fmt.Printf("removed %d unused file(s)\n", removed)
+	for _, err := range errs {
+		log.Print(err)
+	}
+	infof("removed %d unused file(s), reclaiming %s\n", removed, fmtBytes(reclaimed))
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+}
+
+// baseline, if from is set, is the saved build at from that cp hard
+// links unchanged files from when it copies them into the build being
+// saved at to. See -against.
+var baseline struct{ from, to string }
+
+// linkBaseline hard links dst to the file at the same path in the
+// baseline build if that file has the same size, mode, and
+// modification time as src, and reports whether it did.
+func linkBaseline(src, dst string) bool {
+	if baseline.from == "" {
+		return false
+	}
+	rel, err := filepath.Rel(baseline.to, dst)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	old := filepath.Join(baseline.from, rel)
+	st1, err1 := os.Stat(src)
+	st2, err2 := os.Lstat(old)
+	if err1 != nil || err2 != nil || !st2.Mode().IsRegular() ||
+		st1.Size() != st2.Size() || st1.Mode() != st2.Mode() || !st1.ModTime().Equal(st2.ModTime()) {
+		return false
+	}
+	done := logCommand("ln", old, dst)
+	if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Link(old, dst); err != nil {
+		log.Fatal(err)
+	}
+	done(0)
+	curProgress.add(st1.Size())
+	return true
 }
 
 func cp(src, dst string) {
+	copyDedup(src, dst, !*noDedup)
+}
+
+// copyDedup copies src to dst like cp, deduplicating it against the
+// dedup cache if dedup is set.
+func copyDedup(src, dst string, dedup bool) {
+	if linkBaseline(src, dst) {
+		return
+	}
 	data, err := ioutil.ReadFile(src)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	writeFile, xdst := true, dst
-	if !*noDedup {
+	if dedup {
 		hash := fmt.Sprintf("%x", sha1.Sum(data))
 		xdst = filepath.Join(*verDir, "_dedup", hash[:2], hash[2:])
 		if _, err := os.Stat(xdst); err == nil {
@@ -492,9 +2160,7 @@ func cp(src, dst string) {
 		}
 	}
 	if writeFile {
-		if *verbose {
-			fmt.Printf("cp %s %s\n", src, xdst)
-		}
+		done := logCommand("cp", src, xdst)
 		st, err := os.Stat(src)
 		if err != nil {
 			log.Fatal(err)
@@ -508,24 +2174,67 @@ func cp(src, dst string) {
 		if err := os.Chtimes(xdst, st.ModTime(), st.ModTime()); err != nil {
 			log.Fatal(err)
 		}
+		done(int64(len(data)))
 	}
 
 	if dst != xdst {
-		if *verbose {
-			fmt.Printf("ln %s %s\n", xdst, dst)
-		}
+		done := logCommand("ln", xdst, dst)
 		if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
 			log.Fatal(err)
 		}
 		if err := os.Link(xdst, dst); err != nil {
 			log.Fatal(err)
 		}
+		done(0)
+	}
+	curProgress.add(int64(len(data)))
+}
+
+// missingTree reports whether tree, relative to goroot, doesn't exist,
+// as with pkg/include in Go versions without C headers or a -pkg-tree
+// for a GOOS_GOARCH that wasn't built, and notes it with -v.
+func missingTree(goroot, tree string) bool {
+	_, err := os.Lstat(filepath.Join(goroot, tree))
+	if os.IsNotExist(err) {
+		if *verbose {
+			infof("skipping %s, which doesn't exist in %s\n", tree, goroot)
+		}
+		return true
+	} else if err != nil {
+		log.Fatal(err)
 	}
+	return false
 }
 
+// cpR copies the tree src to dst, including the modes and modification
+// times of its files and directories.
 func cpR(src, dst string) {
-	filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+	var dirs copiedDirs
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if saveBoundary.crosses(path, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		if info.IsDir() {
+			return dirs.mkdir(dst+path[len(src):], info)
+		}
+		if info.Mode()&os.ModeSymlink != 0 && sharedSrc(path) != "" {
+			// Keep sharing the shared source tree.
+			target, err := os.Readlink(path)
+			if err == nil {
+				err = os.MkdirAll(filepath.Dir(dst+path[len(src):]), 0777)
+			}
+			if err == nil {
+				err = os.Symlink(target, dst+path[len(src):])
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
 			return nil
 		}
 		base := filepath.Base(path)
@@ -534,6 +2243,50 @@ func cpR(src, dst string) {
 		}
 
 		cp(path, dst+path[len(src):])
+		curStats.add(path, info.Size())
 		return nil
 	})
+	if err == nil {
+		err = dirs.finish()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// copiedDirs records the directories a tree copy creates, so their
+// modes and modification times can be made to match the originals once
+// everything in them has been written. Setting them any earlier would
+// lose the times as files are added and could leave a read-only
+// directory that the rest of the copy can't write into.
+type copiedDirs []copiedDir
+
+type copiedDir struct {
+	path string
+	info os.FileInfo
+}
+
+// mkdir creates directory path for the directory described by info.
+func (d *copiedDirs) mkdir(path string, info os.FileInfo) error {
+	if err := os.MkdirAll(path, 0777); err != nil {
+		return err
+	}
+	*d = append(*d, copiedDir{path, info})
+	return nil
+}
+
+// finish sets the mode and modification time of each directory
+// created by mkdir, deepest first.
+func (d copiedDirs) finish() error {
+	for i := len(d) - 1; i >= 0; i-- {
+		dir := d[i]
+		if err := os.Chmod(dir.path, dir.info.Mode().Perm()); err != nil {
+			return err
+		}
+		mtime := dir.info.ModTime()
+		if err := os.Chtimes(dir.path, mtime, mtime); err != nil {
+			return err
+		}
+	}
+	return nil
 }
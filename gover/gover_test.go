@@ -0,0 +1,198 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerDirFor(t *testing.T) {
+	home := filepath.Join("home", "gopher")
+	tests := []struct {
+		goos string
+		env  map[string]string
+		want string
+	}{
+		{"linux", nil, filepath.Join(home, ".cache", "gover")},
+		{"freebsd", nil, filepath.Join(home, ".cache", "gover")},
+		{"darwin", nil, filepath.Join(home, "Library", "Caches", "gover")},
+		{"windows", nil, filepath.Join(home, "AppData", "Local", "gover")},
+		{"windows", map[string]string{"LOCALAPPDATA": "local"}, filepath.Join("local", "gover")},
+
+		// $XDG_CACHE_HOME overrides the platform default.
+		{"linux", map[string]string{"XDG_CACHE_HOME": "xdg"}, filepath.Join("xdg", "gover")},
+		{"darwin", map[string]string{"XDG_CACHE_HOME": "xdg"}, filepath.Join("xdg", "gover")},
+		{"windows", map[string]string{"XDG_CACHE_HOME": "xdg", "LOCALAPPDATA": "local"}, filepath.Join("xdg", "gover")},
+
+		// $GOVER_DIR overrides everything.
+		{"linux", map[string]string{"GOVER_DIR": "gd", "XDG_CACHE_HOME": "xdg"}, "gd"},
+		{"darwin", map[string]string{"GOVER_DIR": "gd"}, "gd"},
+		{"windows", map[string]string{"GOVER_DIR": "gd", "LOCALAPPDATA": "local"}, "gd"},
+	}
+	for _, test := range tests {
+		getenv := func(key string) string { return test.env[key] }
+		got := verDirFor(test.goos, getenv, home)
+		if got != test.want {
+			t.Errorf("verDirFor(%q, %v) = %q, want %q", test.goos, test.env, got, test.want)
+		}
+	}
+}
+
+// git runs a git command in dir for a test.
+func git(t *testing.T, dir string, args ...string) string {
+	c := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	c.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=gopher", "GIT_AUTHOR_EMAIL=gopher@example.com",
+		"GIT_COMMITTER_NAME=gopher", "GIT_COMMITTER_EMAIL=gopher@example.com")
+	out, err := c.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+// newGitGoroot creates a git repository that looks like a Go tree.
+// The caller must remove it.
+func newGitGoroot(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "gover-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "go")
+	if err := os.MkdirAll(filepath.Join(root, "src", "cmd", "go"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "src", "cmd", "go", "main.go"), []byte("package main\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	git(t, root, "init", "-q")
+	git(t, root, "add", ".")
+	git(t, root, "commit", "-q", "-m", "initial commit")
+	return root
+}
+
+func TestWorktree(t *testing.T) {
+	root := newGitGoroot(t)
+	defer os.RemoveAll(filepath.Dir(root))
+	wt := filepath.Join(filepath.Dir(root), "wt")
+	git(t, root, "worktree", "add", "-q", "--detach", wt)
+	*gitPath, *gorootFlag = "git", wt
+	defer func() { *gitPath, *gorootFlag = "", "" }()
+
+	if st, err := os.Stat(filepath.Join(wt, ".git")); err != nil || st.IsDir() {
+		t.Fatalf("expected %s/.git to be a file", wt)
+	}
+	if !isGitRepo(wt) {
+		t.Fatalf("isGitRepo(%q) = false, want true", wt)
+	}
+
+	want := strings.TrimSpace(git(t, root, "rev-parse", "HEAD"))
+	if hash, diff := getHash(); hash != want || diff != nil {
+		t.Errorf("getHash() = %q, %q; want %q, nil", hash, diff, want)
+	}
+	if commit := gitCmd("cat-file", "commit", "HEAD"); !strings.Contains(commit, "initial commit") {
+		t.Errorf("cat-file commit HEAD = %q, want initial commit", commit)
+	}
+
+	// Changes in the worktree should be reflected in its hash.
+	if err := ioutil.WriteFile(filepath.Join(wt, "src", "cmd", "go", "main.go"), []byte("package main // changed\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if hash, diff := getHash(); !strings.HasPrefix(hash, want+"+") || diff == nil || !bytes.Contains(diff.all, []byte("changed")) {
+		t.Errorf("getHash() = %q, %q; want %s+<diff hash> and a diff", hash, diff, want)
+	}
+}
+
+// TestDirMeta checks that saving a tree with cpR and restoring it with
+// replaceTree keep the modes and modification times of its directories,
+// including read-only ones.
+func TestDirMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gover-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		// RemoveAll can't remove the contents of read-only
+		// directories.
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() {
+				os.Chmod(path, 0755)
+			}
+			return nil
+		})
+		os.RemoveAll(dir)
+	}()
+	oldVerDir := *verDir
+	defer func() { *verDir = oldVerDir }()
+	*verDir = filepath.Join(dir, "gover")
+
+	src := filepath.Join(dir, "src")
+	modes := map[string]os.FileMode{
+		"":            0750,
+		"a":           0700,
+		"a/b":         0555,
+		"a/b/c":       0711,
+		"empty":       0775,
+		"a/b/c/empty": 0500,
+	}
+	for rel := range modes {
+		if err := os.MkdirAll(filepath.Join(src, rel), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, rel := range []string{"a/f", "a/b/f", "a/b/c/f"} {
+		if err := ioutil.WriteFile(filepath.Join(src, rel), []byte(rel), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mtime := time.Date(2016, 3, 4, 5, 6, 7, 0, time.UTC)
+	i := 0
+	for rel, mode := range modes {
+		path := filepath.Join(src, rel)
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+		i++
+		if err := os.Chtimes(path, mtime, mtime.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(what, dst string) {
+		for rel := range modes {
+			want, err := os.Stat(filepath.Join(src, rel))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.Stat(filepath.Join(dst, rel))
+			if err != nil {
+				t.Errorf("%s: %s", what, err)
+				continue
+			}
+			if got.Mode() != want.Mode() {
+				t.Errorf("%s: %s has mode %v, want %v", what, filepath.Join(dst, rel), got.Mode(), want.Mode())
+			}
+			if !got.ModTime().Equal(want.ModTime()) {
+				t.Errorf("%s: %s has modification time %v, want %v", what, filepath.Join(dst, rel), got.ModTime(), want.ModTime())
+			}
+		}
+	}
+	saved := filepath.Join(*verDir, "build", "src")
+	cpR(src, saved)
+	check("cpR", saved)
+	restored := filepath.Join(dir, "restored", "src")
+	replaceTree(saved, restored)
+	check("replaceTree", restored)
+}
@@ -0,0 +1,195 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+var goosGoarchRe = regexp.MustCompile(`^[a-z0-9]+_[a-z0-9]+$`)
+
+// -git names the git binary, so this isn't info -git.
+var infoUpstream = flag.String("upstream", "", "for info, also show how the build's commit relates to git `ref` in the Go tree, such as origin/master")
+
+// buildArches returns the GOOS_GOARCH pairs that have a directory in
+// dir, which is a build's pkg or pkg/tool directory. Variant
+// directories like linux_amd64_race aren't included.
+func buildArches(dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	var arches []string
+	for _, file := range files {
+		if file.IsDir() && goosGoarchRe.MatchString(file.Name()) {
+			arches = append(arches, file.Name())
+		}
+	}
+	return arches
+}
+
+func doInfo(name string) {
+	savePath := resolveBase(name)
+	base := filepath.Base(savePath)
+	builds, err := listBuilds(listNames | listCommit | listMeta)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var info *buildInfo
+	for _, b := range builds {
+		if b.base == base {
+			info = b
+		}
+	}
+	if info == nil {
+		unknownName(name)
+	}
+	meta := readMeta(savePath)
+	if *infoUpstream != "" {
+		checkGitGoroot()
+		if _, _, err := runGit([]string{"-C", goroot(), "rev-parse", "--verify", "--quiet", *infoUpstream + "^{commit}"}); err != nil {
+			log.Fatalf("-upstream %s is not a commit in %s", *infoUpstream, goroot())
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "base:\t%s\n", info.base)
+	if len(info.names) > 0 {
+		fmt.Fprintf(w, "names:\t%s\n", strings.Join(info.names, " "))
+	}
+	if info.commitHash != "" {
+		fmt.Fprintf(w, "commit:\t%s\n", info.commitHash)
+		if _, err := os.Stat(filepath.Join(savePath, "commit")); os.IsNotExist(err) {
+			// Saved with -no-commit, so the commit's date and
+			// message are unknown.
+			fmt.Fprintf(w, "commit object:\tnot saved\n")
+		} else {
+			fmt.Fprintf(w, "date:\t%s\n", formatTime(info.commit.authorDate))
+			if !info.commit.commitDate.Equal(info.commit.authorDate) {
+				fmt.Fprintf(w, "committed:\t%s\n", formatTime(info.commit.commitDate))
+			}
+			fmt.Fprintf(w, "message:\t%s\n", info.commit.topLine)
+		}
+		if *infoUpstream != "" {
+			printUpstream(w, info.commitHash)
+		}
+	} else if *infoUpstream != "" {
+		fmt.Fprintf(w, "upstream:\tunknown; the build has no commit\n")
+	}
+	if info.deltaHash != "" {
+		fmt.Fprintf(w, "diff:\t%s\n", info.deltaHash)
+	}
+	fmt.Fprintf(w, "saved:\t%s\n", formatTime(info.saveTime))
+	if meta.NoSrc {
+		fmt.Fprintf(w, "source:\tnot saved\n")
+	}
+	if hasCompressedSrc(savePath) {
+		fmt.Fprintf(w, "source:\tcompressed\n")
+	}
+	if meta.SharedSrc != "" {
+		fmt.Fprintf(w, "source:\tshared %s\n", meta.SharedSrc)
+	}
+	if len(meta.Include) > 0 {
+		fmt.Fprintf(w, "included:\t%s\n", strings.Join(meta.Include, " "))
+	}
+	if arches := buildArches(filepath.Join(savePath, "pkg")); len(arches) > 0 {
+		fmt.Fprintf(w, "packages:\t%s\n", strings.Join(arches, " "))
+	}
+	if arches := buildArches(filepath.Join(savePath, "pkg", "tool")); len(arches) > 0 {
+		fmt.Fprintf(w, "tools:\t%s\n", strings.Join(arches, " "))
+	}
+	if meta.Goroot != "" {
+		fmt.Fprintf(w, "goroot:\t%s\n", meta.Goroot)
+	}
+	if meta.Goflags != "" {
+		fmt.Fprintf(w, "goflags:\t%s\n", meta.Goflags)
+	}
+	if len(meta.Labels) > 0 {
+		var labels []string
+		for key, value := range meta.Labels {
+			labels = append(labels, key+"="+value)
+		}
+		sort.Strings(labels)
+		fmt.Fprintf(w, "labels:\t%s\n", strings.Join(labels, " "))
+	}
+	if meta.ClonedFrom != "" {
+		fmt.Fprintf(w, "cloned from:\t%s\n", meta.ClonedFrom)
+	}
+	if meta.CopiedFrom != "" {
+		fmt.Fprintf(w, "copied from:\t%s\n", meta.CopiedFrom)
+	}
+	if meta.Env == nil {
+		fmt.Fprintf(w, "env:\tnot recorded\n")
+	} else {
+		var keys []string
+		for key := range meta.Env {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if len(keys) == 0 {
+			fmt.Fprintf(w, "env:\tnone set\n")
+		}
+		for i, key := range keys {
+			label := ""
+			if i == 0 {
+				label = "env:"
+			}
+			fmt.Fprintf(w, "%s\t%s=%s\n", label, key, shellEscape(meta.Env[key]))
+		}
+	}
+	w.Flush()
+}
+
+// printUpstream prints how commit relates to -upstream in the Go tree:
+// whether -upstream contains it and how far apart they are, the
+// nearest tag, and the branches that contain it.
+func printUpstream(w io.Writer, commit string) {
+	git := func(args ...string) (string, bool) {
+		out, _, err := runGit(append([]string{"-C", goroot()}, args...))
+		return strings.TrimSpace(string(out)), err == nil
+	}
+	if _, ok := git("cat-file", "-e", commit+"^{commit}"); !ok {
+		// Saves outlive branches, and may come from another
+		// clone or from import.
+		fmt.Fprintf(w, "upstream:\tunknown; %s doesn't have commit %s\n", goroot(), commit[:7])
+		return
+	}
+	up := *infoUpstream
+	ahead, _ := git("rev-list", "--count", up+".."+commit)
+	behind, _ := git("rev-list", "--count", commit+".."+up)
+	switch {
+	case ahead == "0" && behind == "0":
+		fmt.Fprintf(w, "upstream:\tat %s\n", up)
+	case ahead == "0":
+		fmt.Fprintf(w, "upstream:\tin %s, %s commit(s) behind\n", up, behind)
+	default:
+		base, ok := git("merge-base", commit, up)
+		if !ok {
+			fmt.Fprintf(w, "upstream:\tnot in %s, which shares no history with it\n", up)
+			break
+		}
+		fmt.Fprintf(w, "upstream:\tnot in %s, %s commit(s) ahead and %s behind since %s\n", up, ahead, behind, base[:7])
+	}
+	if desc, ok := git("describe", "--tags", commit); ok {
+		fmt.Fprintf(w, "describe:\t%s\n", desc)
+	}
+	if out, ok := git("branch", "--all", "--format=%(refname:short)", "--contains", commit); ok {
+		branches := strings.Fields(out)
+		if len(branches) == 0 {
+			branches = []string{"none"}
+		}
+		fmt.Fprintf(w, "branches:\t%s\n", strings.Join(branches, " "))
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var listLabels = stringListFlag("label", "for list, list only builds with label `key=value`, or with label key at all (may be repeated)")
+
+// doLabel sets each key=value label of saved build name, or removes
+// the label if value is empty. With no labels, it prints the build's
+// labels.
+func doLabel(name string, labels []string) {
+	savePath := resolveBase(name)
+	if len(labels) == 0 {
+		meta := readMeta(savePath)
+		var keys []string
+		for key := range meta.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("%s=%s\n", key, meta.Labels[key])
+		}
+		return
+	}
+
+	set := make(map[string]string)
+	for _, label := range labels {
+		i := strings.Index(label, "=")
+		if i <= 0 {
+			log.Fatalf("bad label `%s'; it must be key=value, or key= to remove it", label)
+		}
+		set[label[:i]] = label[i+1:]
+	}
+
+	// writeMeta replaces meta.json all at once, so this is safe
+	// even while commands run with the build. Like the caches,
+	// labels aren't written into a build that's being replaced or
+	// removed, and the metadata lock keeps concurrent labels from
+	// undoing each other.
+	base, err := filepath.EvalSymlinks(savePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	base = filepath.Base(base)
+	unlock, err := tryLockBuild(base)
+	if err == errBuildInUse {
+		log.Fatalf("saved build `%s' is being replaced or removed; try again when it's done", base)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer unlock()
+	unlockMeta, err := lockMeta(base)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer unlockMeta()
+	if _, err := os.Stat(savePath); err != nil {
+		log.Fatal(err)
+	}
+	meta := readMeta(savePath)
+	if meta.Labels == nil {
+		meta.Labels = make(map[string]string)
+	}
+	for key, value := range set {
+		if value == "" {
+			delete(meta.Labels, key)
+		} else {
+			meta.Labels[key] = value
+		}
+	}
+	if len(meta.Labels) == 0 {
+		meta.Labels = nil
+	}
+	writeMeta(savePath, meta)
+	if _, err := os.Stat(filepath.Join(savePath, manifestName)); err == nil {
+		updateManifest(savePath, metaName)
+	}
+}
+
+// matchLabels reports whether labels has every label in want, each
+// either key=value or just key.
+func matchLabels(labels map[string]string, want []string) bool {
+	for _, w := range want {
+		if i := strings.Index(w, "="); i >= 0 {
+			if value, ok := labels[w[:i]]; !ok || value != w[i+1:] {
+				return false
+			}
+		} else if _, ok := labels[w]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,376 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+)
+
+var (
+	noColor     = flag.Bool("no-color", false, "for list, don't color output on a terminal (also set by $NO_COLOR)")
+	listReverse = flag.Bool("reverse", false, "for list, list builds from newest to oldest")
+	listLimit   = flag.Int("limit", 0, "for list, list at most `n` builds")
+	listUTC     = flag.Bool("utc", false, "for list, print times in UTC instead of local time")
+	timeFormat  = flag.String("time-format", "2006-01-02T15:04:05", "for list, print times using Go time `layout` or one of "+timePresetNames())
+	listSort    = flag.String("sort", "author", "for list and autobisect, sort by `date`: author (commit author date), committer (commit committer date), or saved (time the build was saved)")
+	sinceCommit = flag.String("since-commit", "", "for list, list only builds whose commit is no older than git `rev`")
+	dirtyOnly   = flag.Bool("dirty-only", false, "for list, list only builds saved with uncommitted changes")
+	cleanOnly   = flag.Bool("clean-only", false, "for list, list only builds saved without uncommitted changes")
+	namesOnly   = flag.Bool("names-only", false, "for list, print only the names and bases of builds, one per line")
+	listFormat  = flag.String("format", "", "for list, print each build using Go `template` (see \"go doc gover\")")
+	listFlat    = flag.Bool("flat", false, "for list, print every build and name in the gover directory on its own line, with what each name links to")
+)
+
+func init() {
+	flag.BoolVar(listFlat, "keep-symlinks", false, "same as -flat")
+}
+
+type buildInfoSorter []*buildInfo
+
+func (s buildInfoSorter) Len() int {
+	return len(s)
+}
+
+func (s buildInfoSorter) Less(i, j int) bool {
+	return s[i].commit.authorDate.Before(s[j].commit.authorDate)
+}
+
+func (s buildInfoSorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// timePresets are the named layouts accepted by -time-format.
+var timePresets = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"rfc1123":     time.RFC1123,
+	"unixdate":    time.UnixDate,
+}
+
+func timePresetNames() string {
+	var names []string
+	for name := range timePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// formatTime formats t for list according to -utc and -time-format.
+func formatTime(t time.Time) string {
+	if *listUTC {
+		t = t.UTC()
+	} else {
+		t = t.Local()
+	}
+	layout := *timeFormat
+	if preset, ok := timePresets[layout]; ok {
+		layout = preset
+	}
+	return t.Format(layout)
+}
+
+// revDate returns the author date of git revision rev in goroot().
+func revDate(rev string) time.Time {
+	checkGitGoroot()
+	out, _, err := runGit([]string{"-C", goroot(), "log", "-1", "--format=%at", rev + "^{commit}", "--"})
+	if _, ok := err.(gitTimeoutError); ok {
+		log.Fatal(err)
+	} else if err != nil {
+		log.Fatalf("%s is not a commit in %s", rev, goroot())
+	}
+	sec, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		log.Fatalf("malformed author date for %s: %q", rev, out)
+	}
+	return time.Unix(sec, 0)
+}
+
+// doMatch prints the saved builds of git revision rev in goroot(),
+// with or without uncommitted changes.
+func doMatch(rev string) {
+	checkGitGoroot()
+	out, _, err := runGit([]string{"-C", goroot(), "rev-parse", "--verify", "-q", rev + "^{commit}"})
+	if _, ok := err.(gitTimeoutError); ok {
+		log.Fatal(err)
+	} else if err != nil {
+		log.Fatalf("%s is not a commit in %s", rev, goroot())
+	}
+	hash := strings.TrimSpace(string(out))
+
+	builds, err := listBuilds(listNames)
+	if err != nil {
+		log.Fatal(err)
+	}
+	found := false
+	for _, info := range builds {
+		if info.commitHash != hash {
+			continue
+		}
+		found = true
+		fmt.Print(info.fullName())
+		if len(info.names) > 0 {
+			fmt.Printf(" %s", info.names)
+		}
+		fmt.Println()
+	}
+	if !found {
+		exitf(exitNotFound, "no saved builds of %s", hash)
+	}
+}
+
+// isDirty reports whether build info was saved with uncommitted
+// changes, which are saved in its diff file.
+func isDirty(info *buildInfo) bool {
+	if info.deltaHash != "" {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(*verDir, info.fullName(), "diff"))
+	return err == nil
+}
+
+// ANSI color codes for list output.
+const (
+	colorHash  = "33" // yellow
+	colorDirty = "31" // red
+	colorDate  = "32" // green
+	colorNames = "36" // cyan
+)
+
+// sortDate returns the date of a build that -sort selects. The build
+// must be listed with listCommit and listMeta.
+func sortDate() func(*buildInfo) time.Time {
+	switch *listSort {
+	case "author":
+		return func(info *buildInfo) time.Time { return info.commit.authorDate }
+	case "committer":
+		return func(info *buildInfo) time.Time { return info.commit.commitDate }
+	case "saved":
+		return func(info *buildInfo) time.Time { return info.saveTime }
+	}
+	log.Fatalf("unknown -sort %q", *listSort)
+	return nil
+}
+
+// sortBuilds sorts builds from oldest to newest by sortDate.
+func sortBuilds(builds []*buildInfo) {
+	date := sortDate()
+	sort.SliceStable(builds, func(i, j int) bool {
+		return date(builds[i]).Before(date(builds[j]))
+	})
+}
+
+func doList() {
+	if *listFlat {
+		doListFlat()
+		return
+	}
+	builds, err := listBuilds(listNames | listCommit | listMeta)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *sinceCommit != "" {
+		since := revDate(*sinceCommit)
+		var keep []*buildInfo
+		for _, info := range builds {
+			if !info.commit.authorDate.Before(since) {
+				keep = append(keep, info)
+			}
+		}
+		builds = keep
+	}
+
+	if len(*listLabels) > 0 {
+		var keep []*buildInfo
+		for _, info := range builds {
+			if matchLabels(readMeta(filepath.Join(*verDir, info.fullName())).Labels, *listLabels) {
+				keep = append(keep, info)
+			}
+		}
+		builds = keep
+	}
+
+	if *dirtyOnly || *cleanOnly {
+		if *dirtyOnly && *cleanOnly {
+			log.Fatal("-dirty-only and -clean-only can't be used together")
+		}
+		var keep []*buildInfo
+		for _, info := range builds {
+			if isDirty(info) == *dirtyOnly {
+				keep = append(keep, info)
+			}
+		}
+		builds = keep
+	}
+
+	date := sortDate()
+	sortBuilds(builds)
+	if *listReverse {
+		for i, j := 0, len(builds)-1; i < j; i, j = i+1, j-1 {
+			builds[i], builds[j] = builds[j], builds[i]
+		}
+	}
+	if *listLimit > 0 && len(builds) > *listLimit {
+		builds = builds[:*listLimit]
+	}
+
+	if *namesOnly {
+		for _, info := range builds {
+			fmt.Println(info.fullName())
+			for _, name := range info.names {
+				fmt.Println(name)
+			}
+		}
+		return
+	}
+
+	if *listFormat != "" {
+		tmpl, err := template.New("format").Parse(*listFormat)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, info := range builds {
+			if err := tmpl.Execute(os.Stdout, newListItem(info)); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println()
+		}
+		return
+	}
+
+	if !isTerminal(os.Stdout) {
+		for _, info := range builds {
+			fmt.Print(info.shortName())
+			if d := date(info); !d.IsZero() {
+				fmt.Printf(" %s", formatTime(d))
+			}
+			if len(info.names) > 0 {
+				fmt.Printf(" %s", info.names)
+			}
+			if info.commit.topLine != "" {
+				fmt.Printf(" %s", info.commit.topLine)
+			}
+			fmt.Println()
+		}
+		return
+	}
+
+	// On a terminal, align the columns and add color.
+	color := !*noColor && os.Getenv("NO_COLOR") == ""
+	paint := func(code, s string) string {
+		if !color {
+			return s
+		}
+		// Paint even empty strings so every cell in a column
+		// has the same number of invisible bytes and
+		// tabwriter still aligns them.
+		return "\x1b[" + code + "m" + s + "\x1b[0m"
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	for _, info := range builds {
+		short, dirty := info.shortName(), ""
+		if i := strings.Index(short, "+"); i >= 0 {
+			short, dirty = short[:i], short[i:]
+		}
+		var dateStr, names string
+		if d := date(info); !d.IsZero() {
+			dateStr = formatTime(d)
+		}
+		if len(info.names) > 0 {
+			names = fmt.Sprint(info.names)
+		}
+		fmt.Fprintf(tw, "%s%s\t%s\t%s\t%s\n", paint(colorHash, short), paint(colorDirty, dirty), paint(colorDate, dateStr), paint(colorNames, names), info.commit.topLine)
+	}
+	tw.Flush()
+}
+
+// A listItem is the data available to list -format templates.
+type listItem struct {
+	Base    string    // Name of the build's directory
+	Hash    string    // Full commit hash; empty if saved with -hash
+	Short   string    // Short name, as shown by list
+	Date    time.Time // Commit author date
+	Commit  time.Time // Commit committer date
+	Saved   time.Time // Time the build was saved
+	TopLine string    // First line of the commit message
+	Names   []string  // Names of the build
+	Dirty   bool      // Whether the tree had uncommitted changes
+
+	path string
+}
+
+// doListFlat prints each entry of the gover directory, other than
+// gover's own, by name. Builds are printed as is and names as
+// "name -> target", where target is what the name's symlink contains,
+// followed by a note if it doesn't refer to a saved build.
+func doListFlat() {
+	files, err := ioutil.ReadDir(*verDir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	for _, file := range files {
+		if isReservedName(file.Name()) {
+			continue
+		}
+		path := filepath.Join(*verDir, file.Name())
+		switch {
+		case file.IsDir():
+			fmt.Println(file.Name())
+		case file.Mode()&os.ModeType == os.ModeSymlink:
+			target, err := os.Readlink(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			note := ""
+			if st, err := os.Stat(path); err != nil {
+				note = " (dangling)"
+			} else if !st.IsDir() {
+				note = " (not a build)"
+			}
+			fmt.Printf("%s -> %s%s\n", file.Name(), target, note)
+		default:
+			fmt.Printf("%s (not a build)\n", file.Name())
+		}
+	}
+}
+
+func newListItem(info *buildInfo) *listItem {
+	return &listItem{
+		Base:    info.fullName(),
+		Hash:    info.commitHash,
+		Short:   info.shortName(),
+		Date:    info.commit.authorDate,
+		Commit:  info.commit.commitDate,
+		Saved:   info.saveTime,
+		TopLine: info.commit.topLine,
+		Names:   info.names,
+		Dirty:   isDirty(info),
+		path:    filepath.Join(*verDir, info.fullName()),
+	}
+}
+
+// Label returns the value of the build's label key, or "" if it
+// doesn't have one.
+func (i *listItem) Label(key string) string {
+	return readMeta(i.path).Labels[key]
+}
+
+// Size returns the total size of the build's files in bytes. This is
+// a method, so it's only computed for templates that use it.
+func (i *listItem) Size() (int64, error) {
+	return treeSize(i.path)
+}
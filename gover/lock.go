@@ -0,0 +1,88 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Saved builds are locked with flock(2) on a file in _locks named
+// after the build's base. Commands that run a build hold a shared lock
+// while they run, and replacing a build with -overwrite-base or
+// removing it holds an exclusive lock, so a build isn't replaced out
+// from under a running command. Updating the caches in a build takes
+// a shared lock without waiting, so they aren't written into a build
+// that's being replaced or removed. Changing a build's metadata
+// in place also holds an exclusive lock on a second file, named
+// metaLockPrefix+base, so concurrent changes don't lose each other.
+const locksDir = "_locks"
+
+const metaLockPrefix = "_meta-"
+
+// errBuildInUse is returned by lockBuild if the build is locked by
+// another process.
+var errBuildInUse = fmt.Errorf("build is in use")
+
+// lockBuild locks saved build base and returns a function that
+// unlocks it. A shared lock waits for any exclusive lock to be
+// released, but an exclusive lock returns errBuildInUse instead of
+// waiting.
+func lockBuild(base string, exclusive bool) (unlock func(), err error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX | syscall.LOCK_NB
+	}
+	return flockBuild(base, how)
+}
+
+// tryLockBuild takes a shared lock on saved build base like lockBuild,
+// but returns errBuildInUse instead of waiting if the build is locked
+// exclusively.
+func tryLockBuild(base string) (unlock func(), err error) {
+	return flockBuild(base, syscall.LOCK_SH|syscall.LOCK_NB)
+}
+
+// lockSharedSrc locks the shared source trees and returns a function
+// that unlocks them, waiting for the lock if necessary. Saving with
+// -share-src holds a shared lock from finding the tree it shares until
+// the build linking to it is in place, and gc holds an exclusive lock
+// from finding unused trees until it has removed them, so gc never
+// removes a tree a save just decided to share.
+func lockSharedSrc(exclusive bool) (unlock func(), err error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	return flockBuild(sharedSrcDir, how)
+}
+
+// lockMeta locks the metadata of saved build base for changing it,
+// waiting for any other change to finish, and returns a function that
+// unlocks it.
+func lockMeta(base string) (unlock func(), err error) {
+	return flockBuild(metaLockPrefix+base, syscall.LOCK_EX)
+}
+
+func flockBuild(base string, how int) (unlock func(), err error) {
+	dir := filepath.Join(*verDir, locksDir)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, base), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errBuildInUse
+		}
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
@@ -0,0 +1,311 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var parallelHash = flag.Bool("parallel-hash", false, "hash up to -parallel files at once when writing and checking manifests")
+
+// manifestName is the name of the file in a saved build that records
+// the hash of every other file in the build. It uses the same format
+// as sha256sum(1), so it can also be checked with "sha256sum -c".
+const manifestName = "manifest.sha256"
+
+type manifestEntry struct {
+	path string // slash-separated, relative to the build root
+	hash string
+}
+
+// hashTree returns manifest entries for every regular file under
+// root, other than the manifest itself and the commit cache, in
+// lexical order. With -parallel-hash, it hashes the files with
+// forEachParallel; the entries are in the same order either way.
+func hashTree(root string) ([]manifestEntry, error) {
+	var entries []manifestEntry
+	var paths []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == manifestName || rel == commitCacheName {
+			return nil
+		}
+		if *parallelHash {
+			entries = append(entries, manifestEntry{path: rel})
+			paths = append(paths, path)
+			return nil
+		}
+		hash, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, manifestEntry{rel, hash})
+		return nil
+	})
+	if err != nil || !*parallelHash {
+		return entries, err
+	}
+
+	var (
+		mu     sync.Mutex
+		hashes = make(map[string]string)
+		errs   = make(map[string]error)
+	)
+	forEachParallel(paths, func(path string) error {
+		hash, err := hashFile(path)
+		mu.Lock()
+		hashes[path], errs[path] = hash, err
+		mu.Unlock()
+		return nil
+	})
+	for i, path := range paths {
+		// Report the first error in walk order, so it doesn't
+		// depend on scheduling.
+		if err := errs[path]; err != nil {
+			return nil, err
+		}
+		entries[i].hash = hashes[path]
+	}
+	return entries, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// writeManifest records the hash of every file in the saved build at
+// savePath.
+func writeManifest(savePath string) {
+	entries, err := hashTree(savePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writeManifestEntries(savePath, entries)
+}
+
+// updateManifest rehashes the files at the given slash-separated
+// paths in the manifest of the saved build at savePath, leaving the
+// rest of the manifest alone so it still catches corruption of other
+// files.
+func updateManifest(savePath string, paths ...string) {
+	entries, err := readManifestEntries(savePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, path := range paths {
+		hash, err := hashFile(filepath.Join(savePath, filepath.FromSlash(path)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		found := false
+		for i := range entries {
+			if entries[i].path == path {
+				entries[i].hash, found = hash, true
+			}
+		}
+		if !found {
+			entries = append(entries, manifestEntry{path, hash})
+		}
+	}
+	writeManifestEntries(savePath, entries)
+}
+
+// updateManifestTrees is like updateManifest, but rehashes every file
+// under the given slash-separated directories, including removing
+// files that no longer exist.
+func updateManifestTrees(savePath string, dirs ...string) {
+	old, err := readManifestEntries(savePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	all, err := hashTree(savePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	inDirs := func(path string) bool {
+		for _, dir := range dirs {
+			if strings.HasPrefix(path, dir+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	var entries []manifestEntry
+	for _, e := range old {
+		if !inDirs(e.path) {
+			entries = append(entries, e)
+		}
+	}
+	for _, e := range all {
+		if inDirs(e.path) {
+			entries = append(entries, e)
+		}
+	}
+	writeManifestEntries(savePath, entries)
+}
+
+func writeManifestEntries(savePath string, entries []manifestEntry) {
+	var buf bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintf(&buf, "%s  %s\n", e.hash, e.path)
+	}
+	if err := replaceFile(filepath.Join(savePath, manifestName), buf.Bytes(), 0666); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// readManifestEntries returns the entries of the manifest of the
+// saved build at savePath, in order.
+func readManifestEntries(savePath string) ([]manifestEntry, error) {
+	f, err := os.Open(filepath.Join(savePath, manifestName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []manifestEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fs := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(fs) != 2 {
+			return nil, fmt.Errorf("malformed line in %s: %q", f.Name(), scanner.Text())
+		}
+		entries = append(entries, manifestEntry{path: fs[1], hash: fs[0]})
+	}
+	return entries, scanner.Err()
+}
+
+// readManifest returns the path to hash mapping recorded in the
+// manifest of the saved build at savePath.
+func readManifest(savePath string) (map[string]string, error) {
+	entries, err := readManifestEntries(savePath)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string)
+	for _, e := range entries {
+		m[e.path] = e.hash
+	}
+	return m, nil
+}
+
+// verifyBuild checks the saved build at savePath against its
+// manifest and returns a description of each discrepancy.
+func verifyBuild(savePath string) ([]string, error) {
+	want, err := readManifest(savePath)
+	if err != nil {
+		return nil, err
+	}
+	have, err := hashTree(savePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, e := range have {
+		hash, ok := want[e.path]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: not in manifest", e.path))
+			continue
+		}
+		delete(want, e.path)
+		if hash != e.hash {
+			problems = append(problems, fmt.Sprintf("%s: checksum mismatch", e.path))
+		}
+	}
+	var missing []string
+	for path := range want {
+		missing = append(missing, path)
+	}
+	sort.Strings(missing)
+	for _, path := range missing {
+		problems = append(problems, fmt.Sprintf("%s: missing", path))
+	}
+	return problems, nil
+}
+
+func doVerify(names []string) {
+	var paths []string
+	if len(names) == 0 {
+		builds, err := listBuilds(0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, b := range builds {
+			paths = append(paths, filepath.Join(*verDir, b.fullName()))
+		}
+	} else {
+		for _, name := range names {
+			// Names are symlinks to the base build directory,
+			// which Walk won't follow.
+			paths = append(paths, resolveBase(name))
+		}
+	}
+
+	var all problemList
+	for _, savePath := range paths {
+		base := filepath.Base(savePath)
+		problems, err := verifyBuild(savePath)
+		if os.IsNotExist(err) {
+			all.add(problem{Severity: severityNote, Build: base, Message: "no manifest"})
+			if !*quiet && !*jsonOut {
+				fmt.Printf("%s: no manifest\n", base)
+			}
+			continue
+		} else if err != nil {
+			all.add(problem{Severity: severityError, Build: base, Message: err.Error()})
+			if !*jsonOut {
+				fmt.Printf("%s: %s\n", base, err)
+			}
+			continue
+		}
+		if len(problems) == 0 {
+			if !*quiet && !*jsonOut {
+				fmt.Printf("%s: ok\n", base)
+			}
+			continue
+		}
+		for _, p := range problems {
+			all.add(problem{Severity: severityError, Build: base, Message: p})
+			if !*jsonOut {
+				fmt.Printf("%s: %s\n", base, p)
+			}
+		}
+	}
+	if *jsonOut {
+		all.printJSON()
+	}
+	all.exit(exitCorrupt)
+}
@@ -0,0 +1,156 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// metaName is the name of the file in a saved build that records its
+// buildMeta.
+const metaName = "meta.json"
+
+// buildMeta records information about a saved build beyond its
+// commit object and diff.
+type buildMeta struct {
+	// SaveTime is when the build was saved.
+	SaveTime time.Time
+
+	// Env records the build-related environment variables listed
+	// in buildEnvVars that were set when the build was saved. It's
+	// nil for builds saved before gover recorded this.
+	Env map[string]string
+
+	// NoSrc is set if the build was saved without its source
+	// tree because of -no-src.
+	NoSrc bool `json:",omitempty"`
+
+	// SharedSrc is the hash of the build's source tree if it's
+	// shared with other builds because of -share-src.
+	SharedSrc string `json:",omitempty"`
+
+	// Goroot is the absolute path of the Go tree the build was
+	// saved from, which is the GOROOT built into its binaries. It's
+	// empty for builds saved before gover recorded this.
+	Goroot string `json:",omitempty"`
+
+	// Bin lists the binTools that were saved in bin. It's nil for
+	// builds saved before gover recorded this.
+	Bin []string `json:",omitempty"`
+
+	// Include lists the slash-separated paths, relative to the Go
+	// tree, that were saved because of -include.
+	Include []string `json:",omitempty"`
+
+	// IncludeUntracked is set if the build's diff and hash include
+	// untracked files because of -include-untracked.
+	IncludeUntracked bool `json:",omitempty"`
+
+	// Goflags are the flags given by -goflags when the build was
+	// saved, which run, with, and env set GOFLAGS to.
+	Goflags string `json:",omitempty"`
+
+	// Labels are the key=value labels set by "gover label".
+	Labels map[string]string `json:",omitempty"`
+
+	// ClonedFrom is the Go tree the build was checked out from by
+	// "gover clone". Goroot is then the scratch worktree it was
+	// built in, which no longer exists.
+	ClonedFrom string `json:",omitempty"`
+
+	// CopiedFrom is the name of the build this build was copied
+	// from by "gover copy".
+	CopiedFrom string `json:",omitempty"`
+}
+
+// buildEnvVars are the environment variables that affect how Go is
+// built and so are recorded with each saved build.
+var buildEnvVars = []string{
+	"CC", "CC_FOR_TARGET", "CXX", "CXX_FOR_TARGET",
+	"CGO_ENABLED", "CGO_CFLAGS", "CGO_CXXFLAGS", "CGO_LDFLAGS",
+	"GO386", "GOAMD64", "GOARM", "GOARM64", "GOMIPS", "GOMIPS64", "GOPPC64", "GORISCV64", "GOWASM",
+	"GOARCH", "GOOS", "GOEXPERIMENT", "GOFLAGS", "GO_GCFLAGS", "GO_LDFLAGS",
+	"GOROOT_BOOTSTRAP", "GOROOT_FINAL",
+}
+
+// buildEnv returns the values of buildEnvVars that are set in the
+// environment.
+func buildEnv() map[string]string {
+	env := make(map[string]string)
+	for _, key := range buildEnvVars {
+		if val, ok := os.LookupEnv(key); ok {
+			env[key] = val
+		}
+	}
+	return env
+}
+
+// restoreBuildEnv returns env with the variables in buildEnvVars set
+// as recorded in saved, and unset if saved doesn't record them.
+func restoreBuildEnv(env []string, saved map[string]string) []string {
+	var out []string
+	for _, kv := range env {
+		if !contains(buildEnvVars, strings.SplitN(kv, "=", 2)[0]) {
+			out = append(out, kv)
+		}
+	}
+	for _, key := range buildEnvVars {
+		if val, ok := saved[key]; ok {
+			out = append(out, key+"="+val)
+		}
+	}
+	return out
+}
+
+// readMeta returns the metadata of the saved build at savePath. Builds
+// saved by older versions of gover have no metadata file, so this
+// returns an empty buildMeta if there is none.
+func readMeta(savePath string) *buildMeta {
+	meta := new(buildMeta)
+	data, err := ioutil.ReadFile(filepath.Join(savePath, metaName))
+	if os.IsNotExist(err) {
+		return meta
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(data, meta); err != nil {
+		exitf(exitCorrupt, "%s: %s", filepath.Join(savePath, metaName), err)
+	}
+	return meta
+}
+
+func writeMeta(savePath string, meta *buildMeta) {
+	data, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		log.Fatal(err)
+	}
+	data = append(data, '\n')
+	if err := replaceFile(filepath.Join(savePath, metaName), data, 0666); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// movedGoroot returns the Go tree the build at savePath was saved from
+// if it no longer exists, which may confuse programs that use the
+// GOROOT built into them rather than $GOROOT. Otherwise, or if the
+// build was made by clone, whose worktree is always gone, it returns
+// "".
+func movedGoroot(savePath string) string {
+	meta := readMeta(savePath)
+	root := meta.Goroot
+	if root == "" || meta.ClonedFrom != "" {
+		return ""
+	}
+	if isGoroot(root) {
+		return ""
+	}
+	return root
+}
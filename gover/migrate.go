@@ -0,0 +1,218 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var migrateCopy = flag.Bool("copy", false, "for migrate, copy saved builds instead of moving them")
+
+// doMigrate moves the saved builds and names in gover directory oldDir
+// to newDir, or copies them with -copy. Each build is copied into
+// newDir, deduplicated against what's already there, and checked
+// against the original before the original is removed, so an
+// interrupted migrate leaves every build in at least one directory.
+func doMigrate(oldDir, newDir string) {
+	oldAbs, err := filepath.Abs(oldDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	newAbs, err := filepath.Abs(newDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if sameDir(oldAbs, newAbs) {
+		log.Fatalf("%s and %s are the same directory", oldDir, newDir)
+	}
+	files, err := ioutil.ReadDir(oldAbs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var builds, names []string
+	for _, file := range files {
+		switch {
+		case isReservedName(file.Name()):
+		case file.Mode()&os.ModeSymlink != 0:
+			names = append(names, file.Name())
+		case file.IsDir():
+			builds = append(builds, file.Name())
+		}
+	}
+	var conflicts []string
+	for _, name := range append(builds, names...) {
+		if _, err := os.Lstat(filepath.Join(newAbs, name)); err == nil {
+			conflicts = append(conflicts, name)
+		}
+	}
+	if len(conflicts) > 0 {
+		log.Fatalf("%s already has %s; remove them or migrate them by hand", newDir, strings.Join(conflicts, " "))
+	}
+	if err := os.MkdirAll(newAbs, 0777); err != nil {
+		log.Fatal(err)
+	}
+
+	// Copy into newDir, so cp deduplicates against it.
+	*verDir = newAbs
+	verb := "moved"
+	if *migrateCopy {
+		verb = "copied"
+	}
+	startProgress("migrating")
+	for _, base := range builds {
+		curProgress.addTotal(filepath.Join(oldAbs, base))
+	}
+	for _, base := range builds {
+		migrateBuild(filepath.Join(oldAbs, base), filepath.Join(newAbs, base))
+		if !*migrateCopy {
+			if err := os.RemoveAll(filepath.Join(oldAbs, base)); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *verbose {
+			infof("%s build `%s'\n", verb, base)
+		}
+	}
+	stopProgress()
+
+	for _, name := range names {
+		migrateName(oldAbs, newAbs, name)
+		if !*migrateCopy {
+			if err := os.Remove(filepath.Join(oldAbs, name)); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	if !*migrateCopy && !hasBuilds(oldAbs) {
+		// Only gover's own bookkeeping is left. Remove it, and
+		// the directory too if nothing else is in it.
+		for _, dir := range []string{"_dedup", sharedSrcDir, "_extracted", "_locks"} {
+			if err := os.RemoveAll(filepath.Join(oldAbs, dir)); err != nil {
+				log.Fatal(err)
+			}
+		}
+		os.Remove(oldAbs)
+	}
+	infof("%s %d build(s) and %d name(s) from %s to %s\n", verb, len(builds), len(names), oldDir, newDir)
+}
+
+// migrateBuild copies the saved build at src to dst, including the
+// shared source tree it links to, and exits if the copy doesn't match
+// the original.
+func migrateBuild(src, dst string) {
+	tmp, err := ioutil.TempDir(*verDir, "_tmp-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		log.Fatal(err)
+	}
+	cpR(src, tmp)
+	if shared := sharedSrc(filepath.Join(src, "src")); shared != "" {
+		// cpR copied the link, which is relative to the build,
+		// so it needs the tree it links to next to it.
+		migrateSharedSrc(shared)
+		link := filepath.Join(tmp, "src")
+		if err := os.Remove(link); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join("..", sharedSrcDir, filepath.Base(shared)), link); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var problems []string
+	for _, d := range diffTrees(src, tmp) {
+		problems = append(problems, string(d.op)+" "+d.path)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, manifestName)); err == nil {
+		p, err := verifyBuild(tmp)
+		if err != nil {
+			log.Fatal(err)
+		}
+		problems = append(problems, p...)
+	}
+	if len(problems) > 0 {
+		os.RemoveAll(tmp)
+		log.Fatalf("copy of build `%s' doesn't match the original; leaving it in place:\n\t%s", filepath.Base(src), strings.Join(problems, "\n\t"))
+	}
+	// Builds saved by older versions of gover use the directory's
+	// modification time as their save time.
+	if st, err := os.Stat(src); err == nil {
+		os.Chtimes(tmp, st.ModTime(), st.ModTime())
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.RemoveAll(tmp)
+		log.Fatal(err)
+	}
+}
+
+// migrateSharedSrc copies the shared source tree at shared into the
+// gover directory, unless it already has it.
+func migrateSharedSrc(shared string) {
+	dst := filepath.Join(*verDir, sharedSrcDir, filepath.Base(shared))
+	if _, err := os.Stat(dst); err == nil {
+		curProgress.addDone(shared)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+		log.Fatal(err)
+	}
+	tmp, err := ioutil.TempDir(*verDir, "_tmp-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		log.Fatal(err)
+	}
+	curProgress.addTotal(shared)
+	cpR(shared, tmp)
+	if len(diffTrees(shared, tmp)) > 0 {
+		os.RemoveAll(tmp)
+		log.Fatalf("copy of shared source tree %s doesn't match the original", shared)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.RemoveAll(tmp)
+		log.Fatal(err)
+	}
+}
+
+// migrateName recreates name, a symlink in oldDir, in newDir. Links to
+// builds in oldDir are made relative, or absolute with
+// -absolute-links, to the same build in newDir. Other links are copied
+// as they are.
+func migrateName(oldDir, newDir, name string) {
+	target, err := os.Readlink(filepath.Join(oldDir, name))
+	if err != nil {
+		log.Fatal(err)
+	}
+	resolved := target
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(oldDir, resolved)
+	}
+	if rel, err := filepath.Rel(oldDir, resolved); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		target = rel
+		if *absoluteLinks {
+			target = filepath.Join(newDir, rel)
+		}
+	}
+	if err := os.Symlink(target, filepath.Join(newDir, name)); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(oldDir, name)); err == nil {
+		if _, err := os.Stat(filepath.Join(newDir, name)); err != nil {
+			log.Fatalf("name `%s' doesn't resolve in %s: %s", name, newDir, err)
+		}
+	}
+	if *verbose {
+		infof("linked `%s' -> %s\n", name, target)
+	}
+}
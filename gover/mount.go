@@ -0,0 +1,59 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mountMarker is the file mount leaves in a mount point, recording the
+// build there, so umount only removes trees mount made.
+const mountMarker = ".gover-mount"
+
+// doMount presents saved build name as a Go tree at dir. gover is
+// built from the standard library alone, which has no FUSE support, so
+// this copies the build into dir, unpacking a compressed source tree,
+// rather than serving it from the saved build.
+func doMount(name, dir string) {
+	savePath := resolveBase(name)
+	base := filepath.Base(savePath)
+	if files, err := ioutil.ReadDir(dir); err == nil && len(files) > 0 {
+		log.Fatalf("mount point %s is not empty", dir)
+	} else if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		log.Fatal(err)
+	}
+	infof("gover can't mount builds with FUSE; copying `%s' to %s instead\n", base, dir)
+
+	// Keep the build from being replaced while it's copied.
+	if unlock, err := lockBuild(base, false); err == nil {
+		defer unlock()
+	}
+	copyBuild(savePath, dir, "mounting")
+	if err := ioutil.WriteFile(filepath.Join(dir, mountMarker), []byte(base+"\n"), 0666); err != nil {
+		log.Fatal(err)
+	}
+	infof("mounted `%s' at %s; run \"gover umount %s\" to remove it\n", base, dir, dir)
+}
+
+// doUmount removes a Go tree made by doMount.
+func doUmount(dir string) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, mountMarker))
+	if os.IsNotExist(err) {
+		log.Fatalf("%s was not mounted by gover mount", dir)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Fatal(err)
+	}
+	infof("unmounted `%s' from %s\n", strings.TrimSpace(string(data)), dir)
+}
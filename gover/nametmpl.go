@@ -0,0 +1,122 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var (
+	nameTemplate   = flag.String("name-template", "", "for save and build without a name, name the build using Go `template` (see \"go doc gover\")")
+	tagLatest      = flag.Bool("tag-latest", false, "for save and build, point a `latest' name at the build, moving it from any older build")
+	nameFromBranch = flag.Bool("name-from-branch", false, "for save and build without a name, name the build after the current git branch, moving the name from any older build")
+)
+
+// A nameContext is the value -name-template is executed with.
+type nameContext struct {
+	Branch string    // current git branch, with / replaced by -; empty if detached
+	Date   string    // current date, as 2006-01-02
+	Time   time.Time // current time
+	Hash   string    // the build's base
+	Short  string    // the build's short name, as printed by list
+	Dirty  bool      // whether the tree has uncommitted changes
+}
+
+// templateName returns the name -name-template gives the build being
+// saved as hash.
+func templateName(hash string, dirty bool) string {
+	tmpl, err := template.New("name").Parse(*nameTemplate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	info := buildInfo{base: hash}
+	if hashPlusRe.MatchString(hash) {
+		parts := strings.SplitN(hash, "+", 2)
+		info.commitHash = parts[0]
+		if len(parts) > 1 {
+			info.deltaHash = parts[1]
+		}
+	}
+	now := time.Now()
+	ctx := &nameContext{
+		Date:  now.Format("2006-01-02"),
+		Time:  now,
+		Hash:  hash,
+		Short: info.shortName(),
+		Dirty: dirty,
+	}
+	ctx.Branch = currentBranch()
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ctx); err != nil {
+		log.Fatal(err)
+	}
+	name := buf.String()
+	if name == "" || name != filepath.Base(name) || isReservedName(name) {
+		log.Fatalf("-name-template gave bad name `%s'", name)
+	}
+	return name
+}
+
+// currentBranch returns the branch checked out in goroot(), with /
+// replaced by - so it can be used as a name, or "" if HEAD is detached
+// or goroot() isn't a git checkout.
+func currentBranch() string {
+	if !isGitRepo(goroot()) {
+		return ""
+	}
+	out, _, err := runGit([]string{"-C", goroot(), "symbolic-ref", "-q", "--short", "HEAD"})
+	if err != nil {
+		return ""
+	}
+	return strings.Replace(strings.TrimSpace(string(out)), "/", "-", -1)
+}
+
+// moveName makes namePath, which is a name for another saved build,
+// name the build saved as hash instead.
+func moveName(hash, namePath string) {
+	if st, err := os.Lstat(namePath); err != nil {
+		log.Fatal(err)
+	} else if st.Mode()&os.ModeSymlink == 0 {
+		log.Fatalf("%s is a saved build, not a name", namePath)
+	}
+	if err := os.Remove(namePath); err != nil {
+		log.Fatal(err)
+	}
+	doLink(hash, namePath)
+}
+
+// latestName is the name -tag-latest maintains. As a real name, it
+// takes precedence over the latest pseudo-name.
+const latestName = "latest"
+
+// doTagLatest points latestName at saved build hash.
+func doTagLatest(hash string) {
+	namePath := filepath.Join(*verDir, latestName)
+	if _, err := os.Lstat(namePath); err == nil {
+		moveName(hash, namePath)
+	} else {
+		doLink(hash, namePath)
+	}
+}
+
+// pinningNames returns the names that keep a build from being pruned:
+// all of names except latestName, which moves to each new build
+// rather than marking one to keep.
+func pinningNames(names []string) []string {
+	var pins []string
+	for _, name := range names {
+		if name != latestName {
+			pins = append(pins, name)
+		}
+	}
+	return pins
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	quiet   = flag.Bool("q", false, "print only errors and the output of query commands like list, not progress or what was done")
+	logJSON = flag.Bool("log-json", false, "print the commands -v prints and what was done as JSON lines")
+)
+
+func init() {
+	flag.BoolVar(quiet, "quiet", false, "same as -q")
+}
+
+// Exit statuses besides 1, which log.Fatal uses for any other error,
+// and 2, which flag uses for usage errors.
+const (
+	exitNotFound = 3 // no saved build has the name
+	exitCorrupt  = 4 // a saved build is corrupt
+	exitWarning  = 5 // doctor or verify found only warnings
+	exitTimeout  = 124
+)
+
+// exitf logs a message like log.Fatalf, but exits with status code.
+func exitf(code int, format string, args ...interface{}) {
+	log.Printf(format, args...)
+	os.Exit(code)
+}
+
+// infof prints an informational message to stderr unless -q is set.
+func infof(format string, args ...interface{}) {
+	if *quiet {
+		return
+	}
+	if *logJSON {
+		msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+		writeLogEvent(&logEvent{Time: time.Now(), Message: msg})
+		return
+	}
+	fmt.Fprintf(os.Stderr, format, args...)
+}
+
+// A logEvent is a line printed to stderr by -log-json. It records
+// either a command, as -v would print it, or a message, as infof
+// would print it.
+type logEvent struct {
+	Time     time.Time
+	Command  string   `json:",omitempty"`
+	Args     []string `json:",omitempty"`
+	Duration float64  `json:",omitempty"` // in seconds
+	Bytes    int64    `json:",omitempty"` // copied by the command
+	Message  string   `json:",omitempty"`
+}
+
+func writeLogEvent(ev *logEvent) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stderr.Write(append(data, '\n'))
+}
+
+// logCommand prints command and args if -v is set, before running
+// the command or doing the operation they describe. It returns a
+// function to call when that's done with the number of bytes it
+// copied, which with -log-json prints the command then, along with
+// how long it took.
+func logCommand(command string, args ...string) (done func(bytes int64)) {
+	if !*verbose {
+		return func(int64) {}
+	}
+	if !*logJSON {
+		line := shellEscape(command)
+		for _, arg := range args {
+			line += " " + shellEscape(arg)
+		}
+		fmt.Fprintf(os.Stderr, "%s\n", line)
+		return func(int64) {}
+	}
+	start := time.Now()
+	return func(bytes int64) {
+		writeLogEvent(&logEvent{
+			Time:     start,
+			Command:  command,
+			Args:     args,
+			Duration: time.Since(start).Seconds(),
+			Bytes:    bytes,
+		})
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// pickAmbiguous asks the user to choose which of the saved builds with
+// bases matching ambiguous name name they meant and returns the chosen
+// base. It exits, as before there was a choice, unless both standard
+// input and standard error are terminals.
+func pickAmbiguous(name string, bases []string) string {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stderr) {
+		log.Fatalf("ambiguous name `%s`", name)
+	}
+	builds, err := listBuilds(listNames | listCommit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var matches []*buildInfo
+	for _, b := range builds {
+		if contains(bases, b.base) {
+			matches = append(matches, b)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "`%s' matches more than one saved build:\n", name)
+	for i, b := range matches {
+		fmt.Fprintf(os.Stderr, "  %d) %s %s", i+1, b.fullName(), formatTime(b.commit.authorDate))
+		if len(b.names) > 0 {
+			fmt.Fprintf(os.Stderr, " %s", b.names)
+		}
+		if b.commit.topLine != "" {
+			fmt.Fprintf(os.Stderr, " %s", b.commit.topLine)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+	fmt.Fprintf(os.Stderr, "choose a build [1-%d]: ", len(matches))
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(os.Stderr)
+		log.Fatalf("ambiguous name `%s`", name)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || n < 1 || n > len(matches) {
+		log.Fatalf("invalid choice %q", strings.TrimSpace(line))
+	}
+	return matches[n-1].fullName()
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+)
+
+// A severity is how bad a problem found by doctor or verify is.
+type severity int
+
+const (
+	severityNote    severity = iota // worth knowing, but not wrong
+	severityWarning                 // may cause trouble
+	severityError                   // broken
+)
+
+var severityNames = []string{"note", "warning", "error"}
+
+func (s severity) String() string { return severityNames[s] }
+
+func (s severity) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// A problem is something doctor or verify found. With -json, they
+// print the problems they find as a JSON array of these.
+type problem struct {
+	Severity severity
+	Build    string `json:",omitempty"` // the saved build, if it's about one
+	Check    string `json:",omitempty"` // the doctor check that found it
+	Message  string
+	Hint     string `json:",omitempty"` // how to fix it
+}
+
+type problemList []problem
+
+func (l *problemList) add(p problem) { *l = append(*l, p) }
+
+// worst returns the highest severity in l, or severityNote if l is
+// empty.
+func (l problemList) worst() severity {
+	worst := severityNote
+	for _, p := range l {
+		if p.Severity > worst {
+			worst = p.Severity
+		}
+	}
+	return worst
+}
+
+// printJSON prints l to standard output as a JSON array.
+func (l problemList) printJSON() {
+	if l == nil {
+		l = problemList{}
+	}
+	data, err := json.MarshalIndent(l, "", "\t")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(append(data, '\n'))
+}
+
+// exit exits with status errStatus if l has any errors, or with
+// exitWarning if its worst problems are warnings. Otherwise it
+// returns.
+func (l problemList) exit(errStatus int) {
+	switch l.worst() {
+	case severityError:
+		os.Exit(errStatus)
+	case severityWarning:
+		os.Exit(exitWarning)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A progress displays the progress of copying a set of files on a
+// terminal. A nil *progress displays nothing, so callers don't need
+// to check whether progress is enabled.
+type progress struct {
+	label             string
+	files, totalFiles int
+	bytes, totalBytes int64
+	last              time.Time
+}
+
+// curProgress is the progress of the copy in progress, if any. cp and
+// writeTar report to it.
+var curProgress *progress
+
+// startProgress sets curProgress to a new progress with the given
+// label, or to nil if stderr isn't a terminal, -q is set, or -v or
+// -log-json is set, since the progress line would be interleaved with
+// the commands being run or the JSON.
+func startProgress(label string) {
+	curProgress = nil
+	if isTerminal(os.Stderr) && !*verbose && !*quiet && !*logJSON {
+		curProgress = &progress{label: label}
+	}
+}
+
+// addTotal adds the files under path to the total to copy.
+func (p *progress) addTotal(path string) {
+	if p == nil {
+		return
+	}
+	filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			p.totalFiles++
+			p.totalBytes += info.Size()
+		}
+		return nil
+	})
+}
+
+// addDone records that all of the files under path have been copied.
+func (p *progress) addDone(path string) {
+	if p == nil {
+		return
+	}
+	done := progress{}
+	done.addTotal(path)
+	p.files += done.totalFiles
+	p.bytes += done.totalBytes
+}
+
+// add records that a file of size bytes has been copied.
+func (p *progress) add(size int64) {
+	if p == nil {
+		return
+	}
+	p.files++
+	p.bytes += size
+	// Redrawing on every file would slow down copying many small
+	// files.
+	if now := time.Now(); now.Sub(p.last) >= 100*time.Millisecond {
+		p.last = now
+		p.draw()
+	}
+}
+
+func (p *progress) draw() {
+	if p.totalFiles == 0 {
+		fmt.Fprintf(os.Stderr, "\r%s: %d files, %s\x1b[K", p.label, p.files, fmtBytes(p.bytes))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\r%s: %d/%d files, %s/%s\x1b[K", p.label, p.files, p.totalFiles, fmtBytes(p.bytes), fmtBytes(p.totalBytes))
+}
+
+// stopProgress erases the progress line and clears curProgress.
+func stopProgress() {
+	if curProgress != nil {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+	}
+	curProgress = nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	pruneKeep = flag.Int("prune-keep", 0, "for save, build, and prune, remove all but the newest `n` unnamed builds (default PruneKeep from the configuration file)")
+	keepFile  = flag.String("keep-file", "", "for prune, remove every unnamed build not listed in `file` (- for stdin)")
+)
+
+// parseAge parses an age such as 60d or 2w, or any duration
+// time.ParseDuration accepts, such as 36h.
+func parseAge(s string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, suffix)); err == nil && strings.HasSuffix(s, suffix) {
+			if n < 0 {
+				return 0, fmt.Errorf("negative age")
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil && d < 0 {
+		err = fmt.Errorf("negative age")
+	}
+	return d, err
+}
+
+// pruneAfterSave removes the oldest unnamed builds beyond the newest
+// -prune-keep, or the configured PruneKeep, by when they were saved.
+// Named builds are never removed and don't count, though a build
+// named only by -tag-latest is unnamed for this.
+func pruneAfterSave() {
+	keep := *pruneKeep
+	if keep == 0 {
+		keep = loadConfig().PruneKeep
+	}
+	if keep <= 0 {
+		return
+	}
+	builds, err := listBuilds(listNames | listMeta)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var unnamed []*buildInfo
+	for _, b := range builds {
+		if len(pinningNames(b.names)) == 0 {
+			unnamed = append(unnamed, b)
+		}
+	}
+	if len(unnamed) <= keep {
+		return
+	}
+	sort.SliceStable(unnamed, func(i, j int) bool {
+		return unnamed[i].saveTime.After(unnamed[j].saveTime)
+	})
+	var prune []string
+	for _, b := range unnamed[keep:] {
+		prune = append(prune, b.fullName())
+	}
+	removeBuilds(prune)
+}
+
+// doPrune removes the unnamed builds not listed in -keep-file, or
+// without it, those pruneAfterSave would.
+func doPrune() {
+	if *keepFile == "" {
+		if *pruneKeep == 0 && loadConfig().PruneKeep <= 0 {
+			log.Fatal("prune requires -keep-file or -prune-keep")
+		}
+		pruneAfterSave()
+		return
+	}
+
+	var r io.Reader = os.Stdin
+	if *keepFile != "-" {
+		f, err := os.Open(*keepFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+	}
+	keep := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		savePath, ok := resolveName(line)
+		if !ok {
+			// It may be a build this machine hasn't saved yet.
+			if *verbose {
+				infof("%s: no saved build `%s'\n", *keepFile, line)
+			}
+			continue
+		}
+		if base, err := filepath.EvalSymlinks(savePath); err == nil {
+			savePath = base
+		}
+		keep[filepath.Base(savePath)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("reading %s: %s", *keepFile, err)
+	}
+
+	builds, err := listBuilds(listNames)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var prune []string
+	for _, b := range builds {
+		if !keep[b.fullName()] && len(pinningNames(b.names)) == 0 {
+			prune = append(prune, b.fullName())
+		}
+	}
+	removeBuilds(prune)
+}
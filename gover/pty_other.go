@@ -0,0 +1,22 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errors.New("pseudo-terminals are only supported on Linux")
+}
+
+func makeRaw(f *os.File) (restore func(), err error) {
+	return nil, errors.New("not supported")
+}
+
+func copyWinsize(from, to *os.File) {}
@@ -0,0 +1,165 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// doRebuild rebuilds saved build name from its recorded commit and
+// diff in a scratch worktree of goroot() and replaces the build's
+// binaries and packages with the result. The build's source tree is
+// left alone.
+func doRebuild(name string) {
+	savePath := resolveBase(name)
+	base := filepath.Base(savePath)
+	if !hashPlusRe.MatchString(base) {
+		log.Fatalf("saved build `%s' has no recorded commit to rebuild from", base)
+	}
+	commit := strings.SplitN(base, "+", 2)[0]
+	meta := readMeta(savePath)
+	checkGitGoroot()
+
+	scratch, err := ioutil.TempDir("", "gover-rebuild-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	wt := filepath.Join(scratch, "go")
+	cleanup := func() {
+		if _, stderr, err := runGit([]string{"-C", goroot(), "worktree", "remove", "--force", wt}); err != nil {
+			os.Stderr.Write(stderr)
+		}
+		os.RemoveAll(scratch)
+		// Forget the worktree even if removing it failed
+		// partway.
+		runGit([]string{"-C", goroot(), "worktree", "prune"})
+	}
+	if _, stderr, err := runGit([]string{"-C", goroot(), "worktree", "add", "-q", "--detach", wt, commit}); err != nil {
+		os.Stderr.Write(stderr)
+		cleanup()
+		log.Fatalf("failed to check out %s", commit)
+	}
+
+	diff := filepath.Join(savePath, "diff")
+	if st, err := os.Stat(diff); err == nil && st.Size() > 0 {
+		if _, stderr, err := runGit([]string{"-C", wt, "apply", "--binary", diff}); err != nil {
+			os.Stderr.Write(stderr)
+			cleanup()
+			log.Fatalf("failed to apply the diff saved with `%s'", base)
+		}
+	}
+
+	// Build with the environment the build was originally saved
+	// with, if it was recorded.
+	env := os.Environ()
+	if meta.Env != nil {
+		env = restoreBuildEnv(env, meta.Env)
+	}
+	if err := buildTree(wt, env); err != nil {
+		cleanup()
+		log.Fatalf("error executing make.bash: %s", err)
+	}
+
+	// Replace everything but the source tree. Copy the new files
+	// next to the build first so a failure doesn't leave it half
+	// replaced.
+	tmp, err := ioutil.TempDir(*verDir, "_tmp-")
+	if err != nil {
+		cleanup()
+		log.Fatal(err)
+	}
+	bin := []string{}
+	for _, binTool := range binTools {
+		src := filepath.Join(wt, "bin", binTool)
+		if _, err := os.Stat(src); err == nil {
+			copyDedup(src, filepath.Join(tmp, "bin", binTool), !*noDedup || *dedupeBin)
+			bin = append(bin, binTool)
+		}
+	}
+	// Replace only the package trees the build has, for the
+	// GOOS_GOARCH it was built for, rather than what saving now
+	// would pick.
+	var trees []string
+	for _, tree := range savedTrees(rebuildOSArch(savePath, meta)) {
+		if tree == "src" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(savePath, tree)); err != nil {
+			continue
+		}
+		src := filepath.Join(wt, tree)
+		if _, err := os.Stat(src); err == nil {
+			cpR(src, filepath.Join(tmp, tree))
+			trees = append(trees, tree)
+		}
+	}
+	cleanup()
+	// Don't swap the files out from under a running command.
+	unlock, err := lockBuild(base, true)
+	if err == errBuildInUse {
+		os.RemoveAll(tmp)
+		log.Fatalf("saved build `%s' is in use by a running command; try again when it's done", base)
+	} else if err != nil {
+		os.RemoveAll(tmp)
+		log.Fatal(err)
+	}
+	defer unlock()
+	for _, dir := range append([]string{"bin"}, trees...) {
+		// Remove the old files rather than overwriting them,
+		// since they may be hard links into the deduplication
+		// cache.
+		err := os.RemoveAll(filepath.Join(savePath, dir))
+		if err == nil {
+			err = os.Rename(filepath.Join(tmp, dir), filepath.Join(savePath, dir))
+		}
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatal(err)
+		}
+	}
+	os.RemoveAll(tmp)
+	// Reread the metadata in case it was labeled during the build.
+	unlockMeta, err := lockMeta(base)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer unlockMeta()
+	meta = readMeta(savePath)
+	meta.Bin = bin
+	// The new binaries have the worktree built in as their GOROOT.
+	// It's gone now, which doctor reports.
+	meta.Goroot = wt
+	writeMeta(savePath, meta)
+
+	if _, err := os.Stat(filepath.Join(savePath, manifestName)); err == nil {
+		updateManifestTrees(savePath, "bin", "pkg")
+		updateManifest(savePath, metaName)
+	}
+	infof("rebuilt `%s'\n", base)
+}
+
+// rebuildOSArch returns the GOOS_GOARCH that the build at savePath was
+// saved for: the GOOS and GOARCH recorded with it, or for builds saved
+// before gover recorded them, the one package tree the build has.
+func rebuildOSArch(savePath string, meta *buildMeta) string {
+	if meta.Env == nil {
+		if arches := buildArches(filepath.Join(savePath, "pkg")); len(arches) == 1 {
+			return arches[0]
+		}
+		return saveOSArch()
+	}
+	goos, goarch := runtime.GOOS, runtime.GOARCH
+	if x := meta.Env["GOOS"]; x != "" {
+		goos = x
+	}
+	if x := meta.Env["GOARCH"]; x != "" {
+		goarch = x
+	}
+	return goos + "_" + goarch
+}
@@ -0,0 +1,186 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+)
+
+var (
+	ref     = flag.String("ref", "", "for save and build, check out, build, and save git `rev`, then restore the current checkout")
+	refDiff = flag.String("diff", "", "with -ref, apply the diff in `file` (- for stdin) to rev before building")
+)
+
+// doSaveRef checks out *ref in goroot(), builds and saves it as name,
+// and then restores the original checkout, including any uncommitted
+// changes.
+//
+// The build runs in a child gover process so that nothing it does,
+// including exiting with log.Fatal, can skip restoring the checkout.
+func doSaveRef(name string) {
+	checkGitGoroot()
+	root := goroot()
+
+	// Resolve everything before changing anything so mistakes
+	// fail harmlessly.
+	rev := strings.TrimSpace(gitCmd("rev-parse", "--verify", *ref+"^{commit}"))
+	orig := strings.TrimSpace(gitCmdStatus(1, "symbolic-ref", "-q", "--short", "HEAD"))
+	if orig == "" {
+		// Detached HEAD.
+		orig = strings.TrimSpace(gitCmd("rev-parse", "HEAD"))
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var diffFile string
+	if *refDiff != "" {
+		diffFile = readRefDiff()
+	}
+
+	// From here on, the checkout must be restored. An interrupt
+	// from the terminal also goes to the build, which exits, so
+	// outlive it and restore the checkout then, and don't start the
+	// build if the interrupt came first.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+
+	stashBefore := stashRef()
+	if gitCmd("status", "--porcelain") != "" {
+		gitCmd("stash", "push", "-q", "--include-untracked", "-m", "gover save -ref "+*ref)
+	}
+	stashed := stashRef() != stashBefore
+
+	applied := false
+	restore := func() {
+		if diffFile != "" {
+			os.Remove(diffFile)
+		}
+		if applied {
+			if _, stderr, err := runGit([]string{"-C", root, "reset", "-q", "--hard"}); err != nil {
+				os.Stderr.Write(stderr)
+			}
+		}
+		if _, stderr, err := runGit([]string{"-C", root, "checkout", "-q", orig}); err != nil {
+			os.Stderr.Write(stderr)
+			if stashed {
+				log.Fatalf("failed to check out %s again; your changes are saved in the stash (git stash pop --index)", orig)
+			}
+			log.Fatalf("failed to check out %s again", orig)
+		}
+		if stashed {
+			if _, stderr, err := runGit([]string{"-C", root, "stash", "pop", "-q", "--index"}); err != nil {
+				os.Stderr.Write(stderr)
+				log.Fatal("failed to restore your changes; they are saved in the stash (git stash pop --index)")
+			}
+		}
+	}
+
+	if _, stderr, err := runGit([]string{"-C", root, "checkout", "-q", "--detach", rev}); err != nil {
+		os.Stderr.Write(stderr)
+		restore()
+		log.Fatalf("failed to check out %s", *ref)
+	}
+
+	if diffFile != "" {
+		// Apply to the index too, so files the diff adds are part
+		// of the build's recorded diff.
+		apply := []string{"-C", root, "apply", "--index", "--binary"}
+		if _, stderr, err := runGit(append(apply, "--check", diffFile)); err != nil {
+			os.Stderr.Write(stderr)
+			restore()
+			log.Fatalf("diff does not apply cleanly to %s", *ref)
+		}
+		applied = true
+		if _, stderr, err := runGit(append(apply, diffFile)); err != nil {
+			os.Stderr.Write(stderr)
+			restore()
+			log.Fatalf("failed to apply diff to %s", *ref)
+		}
+	}
+
+	// Pass along all flags except -ref and -diff themselves and -C
+	// and its alias -goroot, which name the tree instead.
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "ref" || f.Name == "diff" || f.Name == "C" || f.Name == "goroot" {
+			return
+		}
+		if l, ok := f.Value.(*stringList); ok {
+			for _, v := range *l {
+				args = append(args, fmt.Sprintf("-%s=%s", f.Name, v))
+			}
+			return
+		}
+		args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+	})
+	args = append(args, "-C", root, "build")
+	if name != "" {
+		args = append(args, name)
+	}
+	c := exec.Command(exe, args...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	select {
+	case <-sig:
+		restore()
+		os.Exit(130)
+	default:
+	}
+	done := logCommand(exe, args...)
+	err = c.Run()
+	done(0)
+
+	restore()
+	signal.Stop(sig)
+	if ee, ok := err.(*exec.ExitError); ok {
+		os.Exit(ee.ExitCode())
+	} else if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// readRefDiff reads the diff named by -diff and returns the name of a
+// temporary file holding it, or "" if the diff is empty, so it can be read before anything is
+// checked out and git can apply it even if it came from stdin.
+func readRefDiff() string {
+	var diff []byte
+	var err error
+	if *refDiff == "-" {
+		diff, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		diff, err = ioutil.ReadFile(*refDiff)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(diff) == 0 {
+		// A clean build has an empty diff.
+		return ""
+	}
+	f, err := ioutil.TempFile("", "gover-diff-")
+	if err == nil {
+		_, err = f.Write(diff)
+		if err1 := f.Close(); err == nil {
+			err = err1
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return f.Name()
+}
+
+// stashRef returns the commit at the top of the stash, or "" if the
+// stash is empty.
+func stashRef() string {
+	return strings.TrimSpace(gitCmdStatus(1, "rev-parse", "-q", "--verify", "refs/stash"))
+}
@@ -0,0 +1,362 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A remote is another gover directory that saved builds can be pushed
+// to and pulled from. Builds are transferred in export format.
+type remote interface {
+	// bases returns the bases of the saved builds in the remote.
+	bases() ([]string, error)
+
+	// resolve returns the base of the build called name in the
+	// remote, which may be a base or a name.
+	resolve(name string) (string, error)
+
+	// put saves the exported build base read from r in the remote.
+	put(base string, r io.Reader) error
+
+	// get writes build base in the remote to w in export format.
+	get(base string, w io.Writer) error
+
+	// link names build base in the remote.
+	link(name, base string) error
+}
+
+// openRemote returns the remote defined in the configuration file as
+// name. Otherwise, if name looks like a location, it returns the
+// remote at that location.
+func openRemote(name string) remote {
+	loc, ok := loadConfig().Remotes[name]
+	if !ok {
+		if !strings.ContainsAny(name, "/:") {
+			log.Fatalf("unknown remote `%s' (not defined in %s)", name, configPath())
+		}
+		loc = name
+	}
+	if i := strings.Index(loc, ":"); i >= 0 && !strings.Contains(loc[:i], "/") {
+		return &sshRemote{host: loc[:i], dir: loc[i+1:]}
+	}
+	return dirRemote(loc)
+}
+
+// dirRemote is a gover directory on a local or mounted file system.
+type dirRemote string
+
+func (d dirRemote) bases() ([]string, error) {
+	files, err := ioutil.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+	var bases []string
+	for _, file := range files {
+		if file.IsDir() && !isReservedName(file.Name()) {
+			bases = append(bases, file.Name())
+		}
+	}
+	return bases, nil
+}
+
+func (d dirRemote) resolve(name string) (string, error) {
+	path := filepath.Join(string(d), name)
+	if base, err := readLinkBase(path); err == nil {
+		return base, nil
+	}
+	if st, err := os.Stat(path); err != nil || !st.IsDir() {
+		return "", fmt.Errorf("no saved build `%s' in %s", name, d)
+	}
+	return name, nil
+}
+
+func (d dirRemote) put(base string, r io.Reader) error {
+	got, _, err := importTar(r, string(d))
+	if err == nil && got != base {
+		err = fmt.Errorf("archive contains build `%s', not `%s'", got, base)
+	}
+	return err
+}
+
+func (d dirRemote) get(base string, w io.Writer) error {
+	return writeTar(w, filepath.Join(string(d), base), base, nil)
+}
+
+func (d dirRemote) link(name, base string) error {
+	path := filepath.Join(string(d), name)
+	if old, err := readLinkBase(path); err == nil {
+		if old != base {
+			return fmt.Errorf("name `%s' exists in %s and refers to another build", name, d)
+		}
+		return nil
+	}
+	return os.Symlink(base, path)
+}
+
+// sshRemote is a gover directory on another machine, accessed by
+// running sh and tar on it over ssh. The directory may be relative to
+// the remote user's home directory.
+type sshRemote struct {
+	host, dir string
+}
+
+// run runs shell script on the remote host with stdin and stdout
+// connected to r and w.
+func (s *sshRemote) run(script string, r io.Reader, w io.Writer) error {
+	script = "cd " + shellEscape(s.dir) + " && " + script
+	c := exec.Command("ssh", s.host, script)
+	done := logCommand("ssh", s.host, script)
+	c.Stdin, c.Stdout, c.Stderr = r, w, os.Stderr
+	err := c.Run()
+	done(0)
+	if err != nil {
+		return fmt.Errorf("ssh %s: %s", s.host, err)
+	}
+	return nil
+}
+
+func (s *sshRemote) output(script string) (string, error) {
+	var buf bytes.Buffer
+	err := s.run(script, nil, &buf)
+	return buf.String(), err
+}
+
+func (s *sshRemote) bases() ([]string, error) {
+	// A remote that doesn't exist yet has no builds.
+	home := &sshRemote{s.host, "."}
+	out, err := home.output("if cd " + shellEscape(s.dir) + ` 2>/dev/null; then for f in *; do if [ -d "$f" ] && [ ! -L "$f" ]; then echo "$f"; fi; done; fi`)
+	if err != nil {
+		return nil, err
+	}
+	var bases []string
+	for _, base := range strings.Fields(out) {
+		if !isReservedName(base) {
+			bases = append(bases, base)
+		}
+	}
+	return bases, nil
+}
+
+func (s *sshRemote) resolve(name string) (string, error) {
+	n := shellEscape(name)
+	out, err := s.output("if [ -L " + n + " ]; then readlink " + n + "; elif [ -d " + n + " ]; then echo " + n + "; fi")
+	if err != nil {
+		return "", err
+	}
+	out = strings.TrimSpace(out)
+	if out == "" {
+		return "", fmt.Errorf("no saved build `%s' in %s:%s", name, s.host, s.dir)
+	}
+	return filepath.Base(out), nil
+}
+
+func (s *sshRemote) put(base string, r io.Reader) error {
+	// Like importTar, unpack into a temporary directory first.
+	b := shellEscape(base)
+	script := `tmp=$(mktemp -d _tmp-XXXXXX) || exit; ` +
+		`if tar -xf - -C "$tmp" && mv "$tmp"/` + b + " " + b + `; then rmdir "$tmp"; else rm -rf "$tmp"; exit 1; fi`
+	// Create the directory first so pushing to a new remote works.
+	home := &sshRemote{s.host, "."}
+	if err := home.run("mkdir -p "+shellEscape(s.dir), nil, nil); err != nil {
+		return err
+	}
+	return s.run(script, r, nil)
+}
+
+func (s *sshRemote) get(base string, w io.Writer) error {
+	// tar would send the src of a -share-src build as the symlink
+	// it is, which would dangle here, so also send the tree it
+	// links to, and inlineSharedSrc puts that in its place.
+	b := shellEscape(base)
+	script := "if [ -L " + b + "/src ]; then tar -cf - " + b + " -C " + b + "/src/ .; else tar -cf - " + b + "; fi"
+	pr, pw := io.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		err := inlineSharedSrc(pr, w, base)
+		// Stop tar if the archive is bad.
+		pr.CloseWithError(err)
+		errc <- err
+	}()
+	err := s.run(script, nil, pw)
+	pw.CloseWithError(err)
+	if ferr := <-errc; err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// inlineSharedSrc copies the tar archive of build base in r to w. If
+// the archive is followed by entries relative to ".", which are the
+// shared source tree base/src links to, those replace the link.
+func inlineSharedSrc(r io.Reader, w io.Writer, base string) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	rename := func(name string) (string, bool) {
+		if name != "." && name != "./" && !strings.HasPrefix(name, "./") {
+			return name, false
+		}
+		renamed := path.Join(base, "src", strings.TrimPrefix(name, "."))
+		if strings.HasSuffix(name, "/") {
+			renamed += "/"
+		}
+		return renamed, true
+	}
+	var link *tar.Header
+	shared := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if path.Clean(hdr.Name) == path.Join(base, "src") && hdr.Typeflag == tar.TypeSymlink {
+			link = hdr
+			continue
+		}
+		var ok bool
+		if hdr.Name, ok = rename(hdr.Name); ok {
+			shared = true
+			if hdr.Typeflag == tar.TypeLink {
+				hdr.Linkname, _ = rename(hdr.Linkname)
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	if link != nil && !shared {
+		// Not a link to a tree tar could send.
+		if err := tw.WriteHeader(link); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func (s *sshRemote) link(name, base string) error {
+	n, b := shellEscape(name), shellEscape(base)
+	script := "if [ -L " + n + " ]; then " +
+		`[ "$(basename "$(readlink ` + n + `)")" = ` + b + " ] || { echo name " + n + " exists and refers to another build >&2; exit 1; }; " +
+		"else ln -s " + b + " " + n + "; fi"
+	return s.run(script, nil, nil)
+}
+
+// isNameLink returns whether name is a name of a saved build, rather
+// than a base, hash prefix, or pseudo-name.
+func isNameLink(name string) bool {
+	_, err := readLinkBase(filepath.Join(*verDir, name))
+	return err == nil
+}
+
+func doPush(name, remoteName string) {
+	savePath := resolveBase(name)
+	base := filepath.Base(savePath)
+	r := openRemote(remoteName)
+
+	bases, err := r.bases()
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	if contains(bases, base) {
+		infof("remote `%s' already has build `%s'\n", remoteName, base)
+	} else {
+		pushBuild(r, savePath)
+		infof("pushed build `%s' to `%s'\n", base, remoteName)
+	}
+
+	if name != base && isNameLink(name) {
+		if err := r.link(name, base); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func doPull(name, remoteName string) {
+	if name != filepath.Base(name) || isReservedName(name) {
+		log.Fatalf("bad build name `%s'", name)
+	}
+	r := openRemote(remoteName)
+	base, err := r.resolve(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if base != filepath.Base(base) || isReservedName(base) {
+		log.Fatalf("remote `%s' has bad build name `%s'", remoteName, base)
+	}
+
+	if _, err := os.Lstat(filepath.Join(*verDir, base)); err == nil {
+		infof("build `%s' already exists\n", base)
+	} else {
+		pullBuild(r, base)
+		infof("pulled build `%s' from `%s'\n", base, remoteName)
+	}
+
+	if name != base {
+		namePath := filepath.Join(*verDir, name)
+		if old, err := readLinkBase(namePath); err == nil {
+			if old != base {
+				log.Fatalf("name `%s' exists and refers to another build", name)
+			}
+		} else {
+			doLink(base, namePath)
+		}
+	}
+}
+
+// pushBuild copies the saved build at savePath to r.
+func pushBuild(r remote, savePath string) {
+	base := filepath.Base(savePath)
+	startProgress("pushing " + base)
+	curProgress.addTotal(savePath)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, savePath, base, nil))
+	}()
+	err := r.put(base, pr)
+	pr.Close()
+	stopProgress()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// pullBuild copies build base from r to the gover directory.
+func pullBuild(r remote, base string) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(r.get(base, pw))
+	}()
+	got, _, err := importTar(pr, *verDir)
+	pr.Close()
+	if err != nil {
+		log.Fatal(err)
+	} else if got != base {
+		log.Fatalf("remote sent build `%s', not `%s'", got, base)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestPullSharedSrc checks that pulling a -share-src build from an ssh
+// remote brings its source tree along rather than a link to the
+// remote's shared copy.
+func TestPullSharedSrc(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("no tar")
+	}
+	dir, err := ioutil.TempDir("", "gover-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldVerDir, oldPath := *verDir, os.Getenv("PATH")
+	defer func() { *verDir = oldVerDir; os.Setenv("PATH", oldPath) }()
+
+	// Run the remote's side here instead of over ssh.
+	bin := filepath.Join(dir, "bin")
+	if err := os.MkdirAll(bin, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(bin, "ssh"), []byte("#!/bin/sh\nshift\nexec sh -c \"$1\"\n"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("PATH", bin+string(filepath.ListSeparator)+oldPath)
+
+	const base = "0123456789abcdef0123456789abcdef01234567"
+	const hash = "feedfacefeedfacefeedfacefeedfacefeedfacefeedfacefeedfacefeedface"
+	remoteDir := filepath.Join(dir, "remote")
+	shared := filepath.Join(remoteDir, sharedSrcDir, hash)
+	for path, data := range map[string]string{
+		filepath.Join(remoteDir, base, "bin", "go"):   "go",
+		filepath.Join(shared, "cmd", "go", "main.go"): "package main\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Deduplicated files are hard links, which tar sends as links
+	// to the first copy.
+	if err := os.Link(filepath.Join(shared, "cmd", "go", "main.go"), filepath.Join(shared, "cmd", "go", "copy.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..", sharedSrcDir, hash), filepath.Join(remoteDir, base, "src")); err != nil {
+		t.Fatal(err)
+	}
+
+	*verDir = filepath.Join(dir, "local")
+	pullBuild(&sshRemote{host: "remote", dir: remoteDir}, base)
+
+	src := filepath.Join(*verDir, base, "src")
+	if st, err := os.Lstat(src); err != nil {
+		t.Fatal(err)
+	} else if !st.IsDir() {
+		t.Fatalf("pulled %s has mode %v, want a directory", src, st.Mode())
+	}
+	for _, name := range []string{"main.go", "copy.go"} {
+		data, err := ioutil.ReadFile(filepath.Join(src, "cmd", "go", name))
+		if err != nil {
+			t.Error(err)
+		} else if string(data) != "package main\n" {
+			t.Errorf("pulled %s is %q, want %q", name, data, "package main\n")
+		}
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(*verDir, base, "bin", "go")); err != nil || string(data) != "go" {
+		t.Errorf("pulled bin/go is %q, %v; want %q", data, err, "go")
+	}
+}
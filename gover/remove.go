@@ -0,0 +1,220 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+var parallel = flag.Int("parallel", runtime.NumCPU(), "for gc and sync -prune, remove up to `n` directories at once; with -parallel-hash, also hash up to n files at once")
+
+// forEachParallel calls f on each item, running up to -parallel calls
+// at once, and returns the errors they return.
+func forEachParallel(items []string, f func(item string) error) []error {
+	n := *parallel
+	if n < 1 {
+		n = 1
+	}
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+	work := make(chan string)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				if err := f(item); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, item := range items {
+		work <- item
+	}
+	close(work)
+	wg.Wait()
+	return errs
+}
+
+// A removal is a saved build that removing builds would remove.
+type removal struct {
+	base  string
+	names []string
+	size  int64 // space removing the build frees
+}
+
+// A removalPlan is what removing saved builds, or gc, would remove.
+type removalPlan struct {
+	builds    []removal
+	sharedSrc []string // shared source trees gc would remove, by hash
+	dedup     int      // files in the dedup cache gc would remove
+	size      int64    // space removing all of them frees
+}
+
+// planRemoval returns what removing the given saved builds would
+// remove and the total space it would free, without removing
+// anything. With gc, it also plans what gc would remove afterward:
+// the shared source trees that no remaining build uses, and the files
+// in the dedup cache that nothing else uses once those are gone.
+//
+// A file's space is only freed once all of its links are removed, so
+// files also linked from the dedup cache aren't counted unless gc
+// would remove them too.
+func planRemoval(bases []string, gc bool) *removalPlan {
+	builds, err := listBuilds(listNames)
+	if err != nil {
+		log.Fatal(err)
+	}
+	names := make(map[string][]string)
+	for _, b := range builds {
+		names[b.fullName()] = b.names
+	}
+	plan := &removalPlan{}
+	links := make(map[inode]uint64) // in what's removed so far
+	for _, base := range bases {
+		r := removal{base, names[base], countLinks(filepath.Join(*verDir, base), links)}
+		plan.builds = append(plan.builds, r)
+		plan.size += r.size
+	}
+	if !gc {
+		return plan
+	}
+
+	plan.sharedSrc = unusedSharedSrc(bases)
+	for _, tree := range plan.sharedSrc {
+		plan.size += countLinks(filepath.Join(*verDir, sharedSrcDir, tree), links)
+	}
+	dirs, err := filepath.Glob(filepath.Join(*verDir, "_dedup", "*"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, dir := range dirs {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() || !goodDedupPath.MatchString(path) {
+				return nil
+			}
+			st, ok := info.Sys().(*syscall.Stat_t)
+			if ok && uint64(st.Nlink)-links[inode{uint64(st.Dev), uint64(st.Ino)}] == 1 {
+				plan.dedup++
+				plan.size += info.Size()
+			}
+			return nil
+		})
+	}
+	return plan
+}
+
+// countLinks adds the links to each regular file under path to links,
+// and returns the total size of the files whose links are then all
+// counted.
+func countLinks(path string, links map[inode]uint64) int64 {
+	var size int64
+	filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			size += info.Size()
+			return nil
+		}
+		ino := inode{uint64(st.Dev), uint64(st.Ino)}
+		links[ino]++
+		if links[ino] == uint64(st.Nlink) {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// print reports each build and shared source tree in p.
+func (p *removalPlan) print(report func(format string, args ...interface{})) {
+	for _, r := range p.builds {
+		if len(r.names) > 0 {
+			report("remove %s %s\n", r.base, r.names)
+		} else {
+			report("remove %s\n", r.base)
+		}
+	}
+	for _, tree := range p.sharedSrc {
+		report("remove %s\n", filepath.Join(sharedSrcDir, tree))
+	}
+}
+
+// removeBuilds removes the given saved builds and their names. Builds
+// are independent, so it removes several at once. It reports any
+// failures and the space reclaimed, and exits if any removal failed.
+// With -dry-run, it only prints what it would remove.
+func removeBuilds(bases []string) {
+	plan := planRemoval(bases, false)
+	report := infof
+	if *dryRun {
+		report = func(format string, args ...interface{}) {
+			fmt.Printf(format, args...)
+		}
+	}
+	plan.print(report)
+	if *dryRun {
+		report("would remove %d build(s), reclaiming %s\n", len(plan.builds), fmtBytes(plan.size))
+		return
+	}
+
+	var mu sync.Mutex
+	var reclaimed int64
+	work := make(map[string]removal)
+	for _, r := range plan.builds {
+		work[r.base] = r
+	}
+	errs := forEachParallel(bases, func(base string) error {
+		unlock, err := lockBuild(base, true)
+		if err == errBuildInUse {
+			return fmt.Errorf("saved build `%s' is in use by a running command", base)
+		} else if err != nil {
+			return err
+		}
+		defer unlock()
+		r := work[base]
+		for _, name := range r.names {
+			if err := os.Remove(filepath.Join(*verDir, name)); err != nil {
+				return err
+			}
+		}
+		if err := os.RemoveAll(filepath.Join(*verDir, base)); err != nil {
+			return err
+		}
+		// The build's extracted source tree, if any, is
+		// useless without it.
+		if err := os.RemoveAll(filepath.Join(*verDir, extractedDir, base)); err != nil {
+			return err
+		}
+		mu.Lock()
+		reclaimed += r.size
+		mu.Unlock()
+		return nil
+	})
+	for _, err := range errs {
+		log.Print(err)
+	}
+	// Files shared with the deduplication cache aren't freed until
+	// gc removes them, so they don't count.
+	infof("removed %d build(s), reclaiming %s\n", len(bases)-len(errs), fmtBytes(reclaimed))
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	printEnv       = flag.Bool("print-env", false, "for run and with, print the command's environment and the program it would run instead of running it")
+	noPath         = flag.Bool("no-path", false, "for run and with, set only GOROOT, leaving PATH alone and not setting GOTOOLDIR")
+	quietOnSuccess = flag.Bool("quiet-on-success", false, "for run and with, print the command's combined output only if it fails")
+	goflags        = flag.String("goflags", "", "for save and build, record `flags` to set GOFLAGS to when running the build's commands; for run, with, and env, set GOFLAGS to flags instead of what the build recorded")
+)
+
+// spillThreshold is how much output a spillBuffer holds in memory.
+const spillThreshold = 1 << 20
+
+// savedBin returns the binTools saved in the bin directory of the
+// build at savePath. Builds saved before gover recorded this in
+// buildMeta.Bin are checked for each of binTools.
+func savedBin(savePath string) []string {
+	if bin := readMeta(savePath).Bin; bin != nil {
+		return bin
+	}
+	var bin []string
+	for _, binTool := range binTools {
+		if _, err := os.Stat(filepath.Join(savePath, "bin", binTool)); err == nil {
+			bin = append(bin, binTool)
+		}
+	}
+	return bin
+}
+
+// doRun runs cmd, which must be one of the build's saved binTools or
+// "tool", with build name. Other commands are refused rather than
+// left to fail exec in some confusing way; "with" runs anything.
+func doRun(name string, cmd []string) {
+	savePath, ok := resolveName(name)
+	if !ok {
+		unknownName(name)
+	}
+	valid := append(savedBin(savePath), "tool")
+	if !contains(valid, cmd[0]) {
+		log.Fatalf("build `%s' has no command `%s' (valid commands: %s); use \"gover with\" or \"gover env\" to run other commands", name, cmd[0], strings.Join(valid, ", "))
+	}
+	if *noPath && cmd[0] != "tool" {
+		// PATH won't find the build's binary, so name it.
+		goroot, _ := getEnv(savePath)
+		bin, err := filepath.Abs(filepath.Join(goroot, "bin", cmd[0]))
+		if err != nil {
+			log.Fatal(err)
+		}
+		cmd = append([]string{bin}, cmd[1:]...)
+	}
+	doWith(name, cmd)
+}
+
+// checkGoflags exits if flags isn't something go accepts in GOFLAGS.
+func checkGoflags(flags string) {
+	for _, f := range strings.Fields(flags) {
+		if !strings.HasPrefix(f, "-") {
+			log.Fatalf("bad -goflags %q: %s doesn't start with -", flags, f)
+		}
+	}
+}
+
+// buildGoflags returns what to set GOFLAGS to for running commands
+// with the build at savePath: -goflags if it was given, even empty, or
+// else the flags the build was saved with. It reports false if GOFLAGS
+// should be left as it is.
+func buildGoflags(savePath string) (string, bool) {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "goflags" {
+			given = true
+		}
+	})
+	if given {
+		return *goflags, true
+	}
+	if saved := readMeta(savePath).Goflags; saved != "" {
+		return saved, true
+	}
+	return "", false
+}
+
+// printCommand prints the environment of c and then its command line,
+// with the program resolved to the path c would run.
+func printCommand(c *exec.Cmd) {
+	if c.Err != nil {
+		log.Fatal(c.Err)
+	}
+	for _, env := range c.Env {
+		fmt.Println(env)
+	}
+	line := shellEscape(c.Path)
+	for _, arg := range c.Args[1:] {
+		line += " " + shellEscape(arg)
+	}
+	fmt.Println(line)
+}
+
+// holdOutput points the standard output and error of c at a new
+// spillBuffer for -quiet-on-success. Both go to the same buffer, so
+// os/exec copies them from one pipe as the command writes, and a
+// command that floods both can't block on either.
+func holdOutput(c *exec.Cmd) *spillBuffer {
+	held := new(spillBuffer)
+	c.Stdout, c.Stderr = held, held
+	return held
+}
+
+// A spillBuffer holds the output of a command for -quiet-on-success.
+// It keeps up to spillThreshold bytes in memory, and moves everything
+// to a temporary file once there's more. It's safe to write to from
+// several goroutines.
+type spillBuffer struct {
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	file *os.File
+}
+
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.file == nil && b.buf.Len()+len(p) > spillThreshold {
+		f, err := ioutil.TempFile("", "gover-output-")
+		if err != nil {
+			return 0, err
+		}
+		// Nothing else needs the file, so don't leave it
+		// behind, even if gover exits without Close.
+		os.Remove(f.Name())
+		if _, err := b.buf.WriteTo(f); err != nil {
+			f.Close()
+			return 0, err
+		}
+		b.file = f
+	}
+	if b.file != nil {
+		return b.file.Write(p)
+	}
+	return b.buf.Write(p)
+}
+
+// WriteTo writes everything written to b to w.
+func (b *spillBuffer) WriteTo(w io.Writer) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.file == nil {
+		return b.buf.WriteTo(w)
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, b.file)
+}
+
+func (b *spillBuffer) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	return b.file.Close()
+}
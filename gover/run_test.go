@@ -0,0 +1,44 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestHoldOutputFlood checks that -quiet-on-success doesn't deadlock
+// on a command that writes a lot to standard output and error at once,
+// and that it keeps all of it, including what spills to a file.
+func TestHoldOutputFlood(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	const n = 3 * spillThreshold
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	c := exec.CommandContext(ctx, "sh", "-c", "yes out | head -c $N & yes err | head -c $N >&2; wait")
+	c.Env = append(os.Environ(), "N="+strconv.Itoa(n))
+	held := holdOutput(c)
+	defer held.Close()
+	if err := c.Run(); err != nil {
+		t.Fatalf("command failed: %v (%v)", err, ctx.Err())
+	}
+	if held.file == nil {
+		t.Errorf("%d bytes of output didn't spill to a file", 2*n)
+	}
+	var out bytes.Buffer
+	if _, err := held.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.Len(), 2*n; got != want {
+		t.Errorf("held %d bytes, want %d", got, want)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var shareSrc = flag.Bool("share-src", false, "for save and build, share the source tree with other builds that have an identical one")
+
+// With -share-src, a build's src is a symlink to a directory in
+// _src named after the hash of the source tree's contents, which all
+// builds with the same source tree share. gc removes shared trees
+// that no build links to.
+const sharedSrcDir = "_src"
+
+var goodSharedSrcName = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// saveSharedSrc saves the source tree at src as the src of the build
+// being saved at savePath, sharing it with other builds if possible,
+// and returns the hash of the source tree.
+func saveSharedSrc(src, savePath string) string {
+	entries, err := hashTree(src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if saveBoundary != nil {
+		// Share by what cpR copies, not what's mounted in src.
+		kept := entries[:0]
+		for _, e := range entries {
+			if info, err := os.Lstat(filepath.Join(src, filepath.FromSlash(e.path))); err == nil && saveBoundary.contains(info) {
+				kept = append(kept, e)
+			}
+		}
+		entries = kept
+	}
+	h := sha256.New()
+	for _, e := range entries {
+		fmt.Fprintf(h, "%s  %s\n", e.hash, e.path)
+	}
+	hash := fmt.Sprintf("%x", h.Sum(nil))
+
+	shared := filepath.Join(*verDir, sharedSrcDir, hash)
+	if _, err := os.Stat(shared); os.IsNotExist(err) {
+		// Copy into a temporary directory first so an
+		// interrupted save doesn't leave a partial tree that
+		// later saves would share.
+		if err := os.MkdirAll(filepath.Dir(shared), 0777); err != nil {
+			log.Fatal(err)
+		}
+		tmp, err := ioutil.TempDir(*verDir, "_tmp-")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.Chmod(tmp, 0755); err != nil {
+			log.Fatal(err)
+		}
+		cpR(src, tmp)
+		if err := os.Rename(tmp, shared); err != nil {
+			// Another save may have just created it.
+			os.RemoveAll(tmp)
+			if _, err := os.Stat(shared); err != nil {
+				log.Fatal(err)
+			}
+		}
+	} else if err != nil {
+		log.Fatal(err)
+	} else {
+		// Everything is already saved, so count it all as done.
+		curProgress.addDone(src)
+		curStats.addTree(src)
+	}
+
+	if err := os.MkdirAll(savePath, 0777); err != nil {
+		log.Fatal(err)
+	}
+	target := filepath.Join("..", sharedSrcDir, hash)
+	if err := os.Symlink(target, filepath.Join(savePath, "src")); err != nil {
+		log.Fatal(err)
+	}
+	return hash
+}
+
+// sharedSrc returns the path of the shared source tree that the
+// symlink at path links to, or "" if path isn't a link to a shared
+// source tree.
+func sharedSrc(path string) string {
+	target, err := os.Readlink(path)
+	if err != nil || filepath.Base(filepath.Dir(target)) != sharedSrcDir || !goodSharedSrcName.MatchString(filepath.Base(target)) {
+		return ""
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	return target
+}
+
+// unusedSharedSrc returns the hashes of the shared source trees that
+// no saved build links to, other than the builds in except.
+func unusedSharedSrc(except []string) []string {
+	trees, err := ioutil.ReadDir(filepath.Join(*verDir, sharedSrcDir))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	builds, err := listBuilds(0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	used := make(map[string]bool)
+	for _, b := range builds {
+		if contains(except, b.fullName()) {
+			continue
+		}
+		if shared := sharedSrc(filepath.Join(*verDir, b.fullName(), "src")); shared != "" {
+			used[filepath.Base(shared)] = true
+		}
+	}
+	var unused []string
+	for _, tree := range trees {
+		if used[tree.Name()] {
+			continue
+		}
+		if !goodSharedSrcName.MatchString(tree.Name()) {
+			// Be paranoid about removing files.
+			log.Printf("unexpected file in shared source trees: %s", tree.Name())
+			continue
+		}
+		unused = append(unused, tree.Name())
+	}
+	return unused
+}
+
+// removeSharedSrc removes the given shared source trees.
+func removeSharedSrc(trees []string) {
+	removed := 0
+	for _, tree := range trees {
+		if err := os.RemoveAll(filepath.Join(*verDir, sharedSrcDir, tree)); err != nil {
+			log.Printf("failed to remove shared source tree %s: %v", tree, err)
+		} else {
+			removed++
+		}
+	}
+	if removed > 0 {
+		infof("removed %d unused shared source tree(s)\n", removed)
+	}
+}
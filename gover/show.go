@@ -0,0 +1,118 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// doShow copies the file at rel, a slash-separated path relative to
+// the root of saved build name's Go tree, to stdout. A compressed
+// source tree is read without unpacking it.
+func doShow(name, rel string) {
+	savePath := resolveBase(name)
+	base := filepath.Base(savePath)
+	clean := path.Clean(filepath.ToSlash(rel))
+	if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		log.Fatalf("bad path `%s'; it must be relative to the root of the Go tree, like src/runtime/proc.go", rel)
+	}
+
+	var err error
+	if (clean == "src" || strings.HasPrefix(clean, "src/")) && hasCompressedSrc(savePath) {
+		err = showFromTar(filepath.Join(savePath, compressedSrcName), clean)
+	} else {
+		err = showFile(filepath.Join(savePath, filepath.FromSlash(clean)))
+	}
+	if os.IsNotExist(err) {
+		exitf(exitNotFound, "build `%s' has no file %s", base, clean)
+	} else if err != nil {
+		log.Fatalf("%s in build `%s': %s", clean, base, err)
+	}
+}
+
+func showFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if st.IsDir() {
+		return errIsDir
+	}
+	_, err = io.Copy(os.Stdout, f)
+	return err
+}
+
+// errIsDir is returned by showFile and showFromTar if the path is a
+// directory.
+var errIsDir = fmt.Errorf("is a directory")
+
+// showFromTar copies the file name in the archive at file, as written
+// by saveCompressedSrc, to stdout. name may be a link to another file
+// in the archive.
+func showFromTar(file, name string) error {
+	// Links are followed by reading the archive again, since they
+	// may refer to any other file in it.
+	for hops := 0; hops < 40; hops++ {
+		next, err := showTarEntry(file, name)
+		if err != nil || next == "" {
+			return err
+		}
+		name = next
+	}
+	return os.ErrNotExist
+}
+
+// showTarEntry copies the file name in the archive at file to stdout,
+// or returns the name of the file it links to.
+func showTarEntry(file, name string) (link string, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	dr, err := decompressReader(f)
+	if err != nil {
+		return "", err
+	}
+	defer dr.Close()
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return "", os.ErrNotExist
+		} else if err != nil {
+			return "", err
+		}
+		if path.Clean(strings.TrimPrefix(hdr.Name, "./")) != name {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			_, err = io.Copy(os.Stdout, tr)
+			return "", err
+		case tar.TypeDir:
+			return "", errIsDir
+		case tar.TypeLink:
+			return path.Clean(hdr.Linkname), nil
+		case tar.TypeSymlink:
+			if !path.IsAbs(hdr.Linkname) {
+				return path.Join(path.Dir(name), hdr.Linkname), nil
+			}
+		}
+		return "", os.ErrNotExist
+	}
+}
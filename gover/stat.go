@@ -0,0 +1,118 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+var saveStat = flag.Bool("stat", false, "for save and build, print how many bytes were saved from bin, pkg, pkg/tool, and src")
+
+// A saveStats counts the bytes save copies from each part of the Go
+// tree, before deduplication or compression. A nil *saveStats counts
+// nothing, like a nil *progress.
+type saveStats struct {
+	goroot string
+	bytes  map[string]int64
+}
+
+// curStats is the saveStats of the save in progress, if -stat or
+// -dry-run is set. cpR reports to it.
+var curStats *saveStats
+
+// startStats sets curStats to a new saveStats for saving goroot if it
+// will be printed.
+func startStats(goroot string) {
+	curStats = nil
+	if *saveStat || *dryRun {
+		curStats = &saveStats{goroot: goroot, bytes: make(map[string]int64)}
+	}
+}
+
+// statPart returns the part of the Go tree that path, relative to
+// GOROOT, is counted in: bin, pkg/tool, pkg, or src, or the top-level
+// directory for -include paths.
+func statPart(rel string) string {
+	rel = filepath.ToSlash(rel)
+	if strings.HasPrefix(rel, "pkg/tool/") {
+		return "pkg/tool"
+	}
+	return strings.SplitN(rel, "/", 2)[0]
+}
+
+// add records that the file at path, of size bytes, has been saved.
+func (s *saveStats) add(path string, size int64) {
+	if s == nil {
+		return
+	}
+	rel, err := filepath.Rel(s.goroot, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return
+	}
+	s.bytes[statPart(rel)] += size
+}
+
+// addTree records that all of the files under path have been saved.
+func (s *saveStats) addTree(path string) {
+	if s == nil {
+		return
+	}
+	size, err := treeSize(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.add(path, size)
+}
+
+// print prints the bytes saved from each part of the tree, the
+// standard parts first, and the total.
+func (s *saveStats) print() {
+	if s == nil {
+		return
+	}
+	parts := []string{"bin", "pkg", "pkg/tool", "src"}
+	var others []string
+	for part := range s.bytes {
+		if !contains(parts, part) {
+			others = append(others, part)
+		}
+	}
+	sort.Strings(others)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	var total int64
+	for _, part := range append(parts, others...) {
+		size, ok := s.bytes[part]
+		if !ok {
+			continue
+		}
+		total += size
+		fmt.Fprintf(w, "%s\t%s\n", fmtBytes(size), part)
+	}
+	fmt.Fprintf(w, "%s\ttotal\n", fmtBytes(total))
+	w.Flush()
+}
+
+// doSaveDryRun prints how many bytes saving goroot would copy from
+// each part of the tree, without saving anything.
+func doSaveDryRun(goroot string) {
+	startStats(goroot)
+	for _, binTool := range binTools {
+		curStats.addTree(filepath.Join(goroot, "bin", binTool))
+	}
+	for _, tree := range treesToSave(saveOSArch()) {
+		if missingTree(goroot, tree) {
+			continue
+		}
+		curStats.addTree(filepath.Join(goroot, tree))
+	}
+	curStats.print()
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var (
+	dryRun    = flag.Bool("dry-run", false, "for sync, save, and gc, print what would be done, including what would be removed and the space freed, without doing it")
+	syncPrune = flag.Bool("prune", false, "for sync, remove local builds the remote doesn't have instead of pushing them")
+)
+
+// doSync copies saved builds missing from the gover directory or from
+// remoteName to the other. Saved builds are named after the hash of
+// their commit and diff, so a build with the same base on both sides
+// has the same contents and isn't copied.
+func doSync(remoteName string) {
+	r := openRemote(remoteName)
+	remoteBases, err := r.bases()
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	builds, err := listBuilds(listNames)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var localBases []string
+	pinned := make(map[string]bool)
+	for _, b := range builds {
+		localBases = append(localBases, b.fullName())
+		if len(pinningNames(b.names)) > 0 {
+			pinned[b.fullName()] = true
+		}
+	}
+	sort.Strings(localBases)
+	sort.Strings(remoteBases)
+
+	// With -dry-run, what sync would do is the output.
+	report := infof
+	if *dryRun {
+		report = func(format string, args ...interface{}) {
+			fmt.Printf(format, args...)
+		}
+	}
+
+	var prune []string
+	for _, base := range localBases {
+		if contains(remoteBases, base) {
+			continue
+		}
+		if *syncPrune && pinned[base] {
+			// Like prune, keep named builds.
+			report("keep %s (named)\n", base)
+		} else if *syncPrune {
+			prune = append(prune, base)
+		} else {
+			report("push %s\n", base)
+			if !*dryRun {
+				pushBuild(r, filepath.Join(*verDir, base))
+			}
+		}
+	}
+	for _, base := range remoteBases {
+		if contains(localBases, base) {
+			continue
+		}
+		report("pull %s\n", base)
+		if !*dryRun {
+			pullBuild(r, base)
+		}
+	}
+
+	if len(prune) > 0 {
+		removeBuilds(prune)
+	}
+}
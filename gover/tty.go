@@ -0,0 +1,71 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+var ttyFlag = flag.Bool("tty", false, "for with, run the command in a pseudo-terminal, for interactive programs")
+
+// runTTY runs c with a new pseudo-terminal as its controlling terminal
+// and standard input, output, and error, copying standard input to it
+// and its output to c.Stdout. c.Stdout must already be set. If a
+// pseudo-terminal can't be allocated, runTTY warns and runs c as is.
+func runTTY(c *exec.Cmd) error {
+	master, slave, err := openPTY()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: can't allocate a pseudo-terminal: %v; running without one\n", err)
+		return c.Run()
+	}
+	defer master.Close()
+	out := c.Stdout
+	c.Stdin, c.Stdout, c.Stderr = slave, slave, slave
+	// A new session also puts the command in its own process
+	// group, like -timeout wants.
+	c.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+
+	if isTerminal(os.Stdin) {
+		copyWinsize(os.Stdin, master)
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		defer signal.Stop(winch)
+		go func() {
+			for range winch {
+				copyWinsize(os.Stdin, master)
+			}
+		}()
+		// The pseudo-terminal handles line editing and
+		// signals like ^C now.
+		if restore, err := makeRaw(os.Stdin); err == nil {
+			defer restore()
+		}
+	}
+
+	if err := c.Start(); err != nil {
+		slave.Close()
+		return err
+	}
+	// Only the command should have the slave open, so reading the
+	// master ends when the command exits.
+	slave.Close()
+	go io.Copy(master, os.Stdin)
+	done := make(chan struct{})
+	go func() {
+		// Reading the master fails with EIO once the command
+		// exits, which just means the output is done.
+		io.Copy(out, master)
+		close(done)
+	}()
+	err = c.Wait()
+	<-done
+	return err
+}